@@ -2,6 +2,7 @@ package ipset
 
 /*
 #include <stdarg.h>
+#include <stdio.h>
 #include <libipset/ipset.h>
 
 extern void goipsStandardErrorFn(struct ipset *ipset, void *p, int errType, const char *msg);
@@ -39,6 +40,19 @@ int goips_print_outfn(struct ipset_session *session, void *p, const char *fmt, .
 	return 0;
 }
 
+// goips_parse_stream runs the lines in buf as a restore stream, with
+// elements already added skipped as with -exist.
+int goips_parse_stream(struct ipset *ipset, char *buf, size_t len) {
+	FILE *f = fmemopen(buf, len, "r");
+	if (f == NULL) {
+		return -1;
+	}
+	ipset_envopt_set(ipset_session(ipset), IPSET_ENV_EXIST);
+	int r = ipset_parse_stream(ipset, f);
+	fclose(f);
+	return r;
+}
+
 int goips_custom_printf(struct ipset *ipset, void *p) {
 	return ipset_custom_printf(
 		ipset,
@@ -32,11 +32,21 @@ func goipsStandardErrorFn(cset *C.struct_ipset, p unsafe.Pointer, errType C.int,
 func goipsPrintOutFn(p unsafe.Pointer, msg *C.char) {
 	set := gopointer.Restore(p).(*IPSet)
 	gomsg := C.GoString(msg)
-	set.printOut(strings.TrimSpace(gomsg))
+	set.printOut(gomsg)
 }
 
+// customError records the problems libipset reports outside the session,
+// such as parameter and version problems, as the command's error.
 func (set *IPSet) customError(cset *C.struct_ipset, status int, msg string) {
-	fmt.Printf("  customError: %d (%s) msg: `%s'\n", status, status2String(status), msg)
+	if status == C.IPSET_NO_PROBLEM {
+		return
+	}
+
+	set.recentError = &cmdError{
+		Level:   errorLevelError,
+		Status:  status,
+		Message: fmt.Sprintf("%s: %s", status2String(status), msg),
+	}
 }
 
 func (set *IPSet) stdError(cset *C.struct_ipset, errType int, msg string) {
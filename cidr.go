@@ -0,0 +1,125 @@
+package ipset
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+	"sort"
+)
+
+func (r IPRange) Validate() error {
+	if r.First == nil || r.Last == nil {
+		return fmt.Errorf("both ends of the range are required: %w", ErrInvalidElement)
+	}
+	if (r.First.To4() == nil) != (r.Last.To4() == nil) {
+		return fmt.Errorf("%s and %s differ in family: %w", r.First, r.Last, ErrInvalidElement)
+	}
+	if compareIP(r.First, r.Last) > 0 {
+		return fmt.Errorf("range %s ends before it starts: %w", r, ErrInvalidElement)
+	}
+	return nil
+}
+
+// CIDRs returns the smallest list of prefixes that together cover exactly
+// the range, in address order. This is what a hash:net set needs, as it
+// does not take ranges wider than a single prefix without splitting them.
+// An invalid range yields nil.
+func (r IPRange) CIDRs() []*net.IPNet {
+	if r.Validate() != nil {
+		return nil
+	}
+
+	bits := 128
+	if r.First.To4() != nil {
+		bits = 32
+	}
+
+	first := ipToInt(r.First, bits)
+	last := ipToInt(r.Last, bits)
+
+	var nets []*net.IPNet
+	one := big.NewInt(1)
+
+	for first.Cmp(last) <= 0 {
+		// The largest block starting at first that is aligned and does not
+		// go past last.
+		size := int(first.TrailingZeroBits())
+		if first.Sign() == 0 {
+			size = bits
+		}
+		for {
+			end := new(big.Int).Lsh(one, uint(size))
+			end.Add(end, first).Sub(end, one)
+			if end.Cmp(last) <= 0 {
+				break
+			}
+			size--
+		}
+
+		nets = append(nets, &net.IPNet{IP: intToIP(first, bits), Mask: net.CIDRMask(bits-size, bits)})
+		first.Add(first, new(big.Int).Lsh(one, uint(size)))
+	}
+
+	return nets
+}
+
+// AggregateCIDRs merges overlapping and adjacent ranges and returns the
+// smallest list of prefixes covering their union. IPv4 prefixes come
+// before IPv6 ones, each in address order. Invalid ranges are skipped.
+func AggregateCIDRs(ranges []IPRange) []*net.IPNet {
+	var valid []IPRange
+	for _, r := range ranges {
+		if r.Validate() == nil {
+			valid = append(valid, r)
+		}
+	}
+
+	sort.Slice(valid, func(i, j int) bool {
+		return compareIP(valid[i].First, valid[j].First) < 0
+	})
+
+	var merged []IPRange
+	for _, r := range valid {
+		if n := len(merged); n > 0 && adjoins(merged[n-1], r) {
+			if compareIP(r.Last, merged[n-1].Last) > 0 {
+				merged[n-1].Last = r.Last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	var nets []*net.IPNet
+	for _, r := range merged {
+		nets = append(nets, r.CIDRs()...)
+	}
+	return nets
+}
+
+// adjoins reports whether b, which does not start before a, overlaps a or
+// starts right after it.
+func adjoins(a, b IPRange) bool {
+	if (a.First.To4() == nil) != (b.First.To4() == nil) {
+		return false
+	}
+
+	bits := 128
+	if a.First.To4() != nil {
+		bits = 32
+	}
+
+	next := ipToInt(a.Last, bits)
+	next.Add(next, big.NewInt(1))
+	return ipToInt(b.First, bits).Cmp(next) <= 0
+}
+
+func ipToInt(ip net.IP, bits int) *big.Int {
+	if bits == 32 {
+		return new(big.Int).SetBytes(ip.To4())
+	}
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+func intToIP(n *big.Int, bits int) net.IP {
+	return n.FillBytes(make(net.IP, bits/8))
+}
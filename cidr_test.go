@@ -0,0 +1,267 @@
+package ipset
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func netStrings(nets []*net.IPNet) string {
+	s := make([]string, len(nets))
+	for i, n := range nets {
+		s[i] = n.String()
+	}
+	return strings.Join(s, " ")
+}
+
+func mustParseRange(s string) IPRange {
+	r, err := ParseIPRange(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// rawRange builds the range first-last without validating it, as
+// ParseIPRange would.
+func rawRange(s string) IPRange {
+	first, last, _ := strings.Cut(s, "-")
+	return IPRange{First: net.ParseIP(first), Last: net.ParseIP(last)}
+}
+
+func TestIPRangeCIDRs(t *testing.T) {
+	cases := []struct {
+		r        string
+		expected string
+	}{
+		{"1.2.3.4-1.2.3.4", "1.2.3.4/32"},
+		{"1.2.3.4-1.2.3.50", "1.2.3.4/30 1.2.3.8/29 1.2.3.16/28 1.2.3.32/28 1.2.3.48/31 1.2.3.50/32"},
+		{"10.0.0.0-10.255.255.255", "10.0.0.0/8"},
+		{"0.0.0.0-255.255.255.255", "0.0.0.0/0"},
+		{"255.255.255.254-255.255.255.255", "255.255.255.254/31"},
+		{"2001:db8::-2001:db8::ffff", "2001:db8::/112"},
+		{"2001:db8::1-2001:db8::4", "2001:db8::1/128 2001:db8::2/127 2001:db8::4/128"},
+		{"::-ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::/0"},
+		{"1.2.3.5-1.2.3.4", ""},
+		{"1.2.3.4-2001:db8::1", ""},
+	}
+
+	for _, c := range cases {
+		if s := netStrings(rawRange(c.r).CIDRs()); s != c.expected {
+			t.Errorf("%s: expected '%s', was '%s'", c.r, c.expected, s)
+		}
+	}
+}
+
+func TestAggregateCIDRs(t *testing.T) {
+	ranges := []IPRange{
+		mustParseRange("2001:db8::/33"),
+		mustParseRange("10.0.1.0/24"),
+		mustParseRange("10.0.0.0-10.0.0.255"),
+		mustParseRange("2001:db8:8000::/33"),
+		mustParseRange("10.0.0.128/25"),
+		rawRange("192.0.2.9-192.0.2.7"),
+		mustParseRange("10.0.3.0/24"),
+	}
+
+	expected := "10.0.0.0/23 10.0.3.0/24 2001:db8::/32"
+	if s := netStrings(AggregateCIDRs(ranges)); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+}
+
+func TestIPRangeValidate(t *testing.T) {
+	invalid := []IPRange{
+		{},
+		rawRange("1.2.3.5-1.2.3.4"),
+		rawRange("1.2.3.4-2001:db8::1"),
+	}
+
+	for _, r := range invalid {
+		if err := r.Validate(); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%s: expected ErrInvalidElement, was %v", r, err)
+		}
+		if _, err := New().AddElement("bl4", r); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%s: expected ErrInvalidElement on add, was %v", r, err)
+		}
+	}
+
+	if err := mustParseRange("1.2.3.4-1.2.3.4").Validate(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestAddDelRange(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	r := mustParseRange("10.0.0.1-10.0.0.3")
+	if _, err := set.AddElement(namedSetV4, r); err != nil {
+		t.Fatalf("unexpected error adding range: %v", err)
+	}
+
+	for _, addr := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		found, err := set.Test(namedSetV4, net.ParseIP(addr))
+		if err != nil || !found {
+			t.Errorf("expected %s in set, found %v error %v", addr, found, err)
+		}
+	}
+
+	if _, err := set.DelElement(namedSetV4, mustParseRange("10.0.0.2-10.0.0.3")); err != nil {
+		t.Fatalf("unexpected error deleting range: %v", err)
+	}
+
+	members, err := set.Members(namedSetV4)
+	if err != nil {
+		t.Fatalf("unexpected error listing members: %v", err)
+	}
+	if s := strings.Join(members, " "); s != "1.2.3.4 10.0.0.1" && s != "10.0.0.1 1.2.3.4" {
+		t.Errorf("expected members 1.2.3.4 and 10.0.0.1, was %v", members)
+	}
+}
+
+// randomRange is a valid range of either family, with ends that are often
+// close together or on prefix boundaries so that edge cases are common.
+// IPv6 ranges neither start nor end among the IPv4-mapped addresses, which
+// net.IP cannot tell apart from IPv4 ones.
+type randomRange IPRange
+
+func (randomRange) Generate(rand *rand.Rand, size int) reflect.Value {
+	bits := 32
+	if rand.Intn(2) == 0 {
+		bits = 128
+	}
+
+	for {
+		max := new(big.Int).Lsh(big.NewInt(1), uint(bits))
+		first := new(big.Int).Rand(rand, max)
+		if rand.Intn(2) == 0 {
+			// Clear some low bits to land on a prefix boundary.
+			k := uint(rand.Intn(bits))
+			first.Rsh(first, k).Lsh(first, k)
+		}
+
+		span := new(big.Int).Lsh(big.NewInt(1), uint(rand.Intn(bits+1)))
+		last := new(big.Int).Add(first, new(big.Int).Rand(rand, span))
+		if last.Cmp(max) >= 0 {
+			last.Sub(max, big.NewInt(1))
+		}
+
+		r := randomRange{First: intToIP(first, bits), Last: intToIP(last, bits)}
+		if bits == 32 || (r.First.To4() == nil && r.Last.To4() == nil) {
+			return reflect.ValueOf(r)
+		}
+	}
+}
+
+// checkCovers verifies that nets are aligned, in order and together cover
+// exactly the addresses from first to last.
+func checkCovers(nets []*net.IPNet, first, last net.IP) bool {
+	if len(nets) == 0 {
+		return false
+	}
+
+	bits := len(first) * 8
+	next := ipToInt(first, bits)
+
+	for _, n := range nets {
+		ones, nbits := n.Mask.Size()
+		if nbits != bits || !n.IP.Equal(n.IP.Mask(n.Mask)) {
+			return false
+		}
+		if ipToInt(n.IP, bits).Cmp(next) != 0 {
+			return false
+		}
+		next.Add(next, new(big.Int).Lsh(big.NewInt(1), uint(bits-ones)))
+	}
+
+	return next.Sub(next, big.NewInt(1)).Cmp(ipToInt(last, bits)) == 0
+}
+
+// isMinimal verifies that no two consecutive prefixes are the halves of a
+// larger one, which is what a minimal cover of a contiguous range means.
+func isMinimal(nets []*net.IPNet) bool {
+	for i := 1; i < len(nets); i++ {
+		a, _ := nets[i-1].Mask.Size()
+		b, bits := nets[i].Mask.Size()
+		if a == b && a > 0 {
+			parent := &net.IPNet{IP: nets[i-1].IP, Mask: net.CIDRMask(a-1, bits)}
+			if parent.IP.Equal(parent.IP.Mask(parent.Mask)) && parent.Contains(nets[i].IP) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestIPRangeCIDRsProperties(t *testing.T) {
+	f := func(rr randomRange) bool {
+		r := IPRange(rr)
+		nets := r.CIDRs()
+		return checkCovers(nets, r.First, r.Last) && isMinimal(nets)
+	}
+
+	if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAggregateCIDRsProperties(t *testing.T) {
+	f := func(rrs []randomRange) bool {
+		var ranges []IPRange
+		for _, rr := range rrs {
+			ranges = append(ranges, IPRange(rr))
+		}
+		nets := AggregateCIDRs(ranges)
+
+		// Every address in a range is covered, and the ends of every
+		// prefix lie in some range.
+		covered := func(ip net.IP) bool {
+			for _, n := range nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+			return false
+		}
+		inRange := func(ip net.IP) bool {
+			bits := len(ip) * 8
+			n := ipToInt(ip, bits)
+			for _, r := range ranges {
+				if len(r.First) == len(ip) && ipToInt(r.First, bits).Cmp(n) <= 0 && n.Cmp(ipToInt(r.Last, bits)) <= 0 {
+					return true
+				}
+			}
+			return false
+		}
+
+		for _, r := range ranges {
+			if !covered(r.First) || !covered(r.Last) {
+				return false
+			}
+		}
+		for i, n := range nets {
+			last := IPRangeFromNet(n).Last
+			if !inRange(n.IP) || !inRange(last) {
+				return false
+			}
+			if i > 0 && nets[i-1].Contains(n.IP) {
+				return false
+			}
+		}
+
+		return isMinimal(nets)
+	}
+
+	if err := quick.Check(f, &quick.Config{MaxCount: 500}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/tancred/ipset"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitNotFound
+	exitExists
+	exitNotMember
+)
+
+const usage = `usage: ipsetctl [-netns PATH] [-json] COMMAND [ARGS]
+
+commands:
+  create NAME [TYPE] [PARAM VALUE | FLAG ...]
+  destroy NAME
+  add NAME ELEMENT [timeout N] [comment TEXT] [nomatch]
+  del NAME ELEMENT
+  test NAME ELEMENT [nomatch]
+  list [NAME]
+  flush NAME
+  rename FROM TO
+  swap FROM TO
+  save [NAME]
+  restore < FILE
+  info NAME
+
+list and info print JSON instead of text with -json.
+
+exit status:
+  0 success, 1 error, 2 usage, 3 set not found, 4 set exists,
+  5 element not a member (test)
+`
+
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// element is an element given on the command line, passed on to libipset
+// verbatim.
+type element string
+
+func (e element) String() string {
+	return string(e)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	flags := flag.NewFlagSet("ipsetctl", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	netns := flags.String("netns", "", "operate in the network namespace at `path`")
+	asJSON := flags.Bool("json", false, "print list and info output as JSON")
+
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	set, err := open(*netns)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipsetctl: %v\n", err)
+		return exitError
+	}
+	defer set.Close()
+
+	code, err := dispatch(set, flags.Arg(0), flags.Args()[1:], *asJSON)
+
+	var uerr usageError
+	switch {
+	case errors.As(err, &uerr):
+		fmt.Fprintf(os.Stderr, "ipsetctl: %v\n\n%s", err, usage)
+		return exitUsage
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "ipsetctl: %v\n", err)
+		return exitCode(err)
+	}
+
+	return code
+}
+
+func open(netns string) (*ipset.IPSet, error) {
+	if netns != "" {
+		return ipset.NewNetNS(netns)
+	}
+	return ipset.New(), nil
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ipset.ErrSetNotFound):
+		return exitNotFound
+	case errors.Is(err, ipset.ErrSetExists):
+		return exitExists
+	}
+	return exitError
+}
+
+func dispatch(set *ipset.IPSet, cmd string, args []string, asJSON bool) (int, error) {
+	switch cmd {
+	case "create":
+		return exitOK, create(set, args)
+	case "destroy":
+		if len(args) != 1 {
+			return exitUsage, usageError("destroy takes a set name")
+		}
+		return exitOK, set.Destroy(args[0])
+	case "add":
+		return exitOK, add(set, args)
+	case "del":
+		if len(args) != 2 {
+			return exitUsage, usageError("del takes a set name and an element")
+		}
+		_, err := set.DelElement(args[0], element(args[1]))
+		return exitOK, err
+	case "test":
+		return test(set, args)
+	case "list":
+		return exitOK, list(set, args, asJSON)
+	case "flush":
+		if len(args) != 1 {
+			return exitUsage, usageError("flush takes a set name")
+		}
+		return exitOK, set.Flush(args[0])
+	case "rename":
+		if len(args) != 2 {
+			return exitUsage, usageError("rename takes two set names")
+		}
+		return exitOK, set.Rename(args[0], args[1])
+	case "swap":
+		if len(args) != 2 {
+			return exitUsage, usageError("swap takes two set names")
+		}
+		return exitOK, set.Swap(args[0], args[1])
+	case "save":
+		return exitOK, save(set, args)
+	case "restore":
+		if len(args) != 0 {
+			return exitUsage, usageError("restore reads from standard input")
+		}
+		return exitOK, set.Restore(os.Stdin)
+	case "info":
+		return exitOK, info(set, args, asJSON)
+	}
+
+	return exitUsage, usageError(fmt.Sprintf("unknown command '%s'", cmd))
+}
+
+func create(set *ipset.IPSet, args []string) error {
+	if len(args) == 0 {
+		return usageError("create takes a set name")
+	}
+
+	opts, err := createOptions(args[1:])
+	if err != nil {
+		return err
+	}
+
+	return set.Create(args[0], opts...)
+}
+
+// createOptions turns the type and parameters of a create command into
+// create options.
+func createOptions(args []string) ([]ipset.CreateOption, error) {
+	var opts []ipset.CreateOption
+
+	if len(args) > 0 {
+		opts = append(opts, ipset.CreateOptionType(ipset.SetType(args[0])))
+		args = args[1:]
+	}
+
+	for len(args) > 0 {
+		param := args[0]
+		args = args[1:]
+
+		switch param {
+		case "counters":
+			opts = append(opts, ipset.CreateOptionCounters())
+			continue
+		case "comment":
+			opts = append(opts, ipset.CreateOptionComment())
+			continue
+		case "skbinfo":
+			opts = append(opts, ipset.CreateOptionSkbInfo())
+			continue
+		case "forceadd":
+			opts = append(opts, ipset.CreateOptionForceAdd())
+			continue
+		}
+
+		if len(args) == 0 {
+			return nil, usageError(fmt.Sprintf("parameter '%s' needs a value", param))
+		}
+		val := args[0]
+		args = args[1:]
+
+		opt, err := createOption(param, val)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+
+	return opts, nil
+}
+
+func createOption(param string, val string) (ipset.CreateOption, error) {
+	switch param {
+	case "family":
+		return ipset.CreateOptionFamily(ipset.Family(val)), nil
+	case "range":
+		if r, err := ipset.ParsePortRange(val); err == nil {
+			return ipset.CreateOptionPortRange(r), nil
+		}
+		r, err := ipset.ParseIPRange(val)
+		if err != nil {
+			return nil, usageError(err.Error())
+		}
+		return ipset.CreateOptionIPRange(r), nil
+	case "markmask":
+		n, err := strconv.ParseUint(val, 0, 32)
+		if err != nil {
+			return nil, usageError(fmt.Sprintf("invalid markmask '%s'", val))
+		}
+		return ipset.CreateOptionMarkMask(uint32(n)), nil
+	case "bitmask":
+		mask := net.ParseIP(val)
+		if mask == nil {
+			return nil, usageError(fmt.Sprintf("invalid bitmask '%s'", val))
+		}
+		return ipset.CreateOptionBitmask(mask), nil
+	case "initval":
+		n, err := strconv.ParseUint(val, 0, 32)
+		if err != nil {
+			return nil, usageError(fmt.Sprintf("invalid initval '%s'", val))
+		}
+		return ipset.CreateOptionInitVal(uint32(n)), nil
+	}
+
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return nil, usageError(fmt.Sprintf("invalid value '%s' for %s", val, param))
+	}
+
+	switch param {
+	case "timeout":
+		return ipset.CreateOptionTimeout(n), nil
+	case "size":
+		return ipset.CreateOptionSize(n), nil
+	case "netmask":
+		return ipset.CreateOptionNetmask(n), nil
+	case "hashsize":
+		return ipset.CreateOptionHashSize(n), nil
+	case "maxelem":
+		return ipset.CreateOptionMaxElem(n), nil
+	case "bucketsize":
+		return ipset.CreateOptionBucketSize(n), nil
+	}
+
+	return nil, usageError(fmt.Sprintf("unknown parameter '%s'", param))
+}
+
+func add(set *ipset.IPSet, args []string) error {
+	if len(args) < 2 {
+		return usageError("add takes a set name and an element")
+	}
+
+	opts, err := entryOptions(args[2:])
+	if err != nil {
+		return err
+	}
+
+	_, err = set.AddElement(args[0], element(args[1]), opts...)
+	return err
+}
+
+func entryOptions(args []string) ([]ipset.EntryOption, error) {
+	var opts []ipset.EntryOption
+
+	for len(args) > 0 {
+		param := args[0]
+		args = args[1:]
+
+		if param == "nomatch" {
+			opts = append(opts, ipset.EntryOptionNoMatch())
+			continue
+		}
+
+		if len(args) == 0 {
+			return nil, usageError(fmt.Sprintf("option '%s' needs a value", param))
+		}
+		val := args[0]
+		args = args[1:]
+
+		switch param {
+		case "timeout":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return nil, usageError(fmt.Sprintf("invalid timeout '%s'", val))
+			}
+			opts = append(opts, ipset.EntryOptionTimeout(n))
+		case "comment":
+			opts = append(opts, ipset.EntryOptionComment(val))
+		default:
+			return nil, usageError(fmt.Sprintf("unknown option '%s'", param))
+		}
+	}
+
+	return opts, nil
+}
+
+func test(set *ipset.IPSet, args []string) (int, error) {
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[2] != "nomatch") {
+		return exitUsage, usageError("test takes a set name, an element and optionally nomatch")
+	}
+
+	var opts []ipset.EntryOption
+	if len(args) == 3 {
+		opts = append(opts, ipset.EntryOptionNoMatch())
+	}
+
+	found, err := set.TestElement(args[0], element(args[1]), opts...)
+	if err != nil {
+		return exitError, err
+	}
+
+	if !found {
+		fmt.Printf("%s is NOT in set %s\n", args[1], args[0])
+		return exitNotMember, nil
+	}
+
+	fmt.Printf("%s is in set %s\n", args[1], args[0])
+	return exitOK, nil
+}
+
+// listing is a set as printed by list -json.
+type listing struct {
+	ipset.Stats
+	Members []ipset.Entry `json:"members"`
+}
+
+func list(set *ipset.IPSet, args []string, asJSON bool) error {
+	if len(args) > 1 {
+		return usageError("list takes at most one set name")
+	}
+
+	names := args
+	if len(names) == 0 {
+		var err error
+		names, err = set.Names()
+		if err != nil {
+			return err
+		}
+	}
+
+	listings := make([]listing, 0, len(names))
+
+	for _, name := range names {
+		stats, err := set.Stats(name)
+		if err != nil {
+			return err
+		}
+		entries, err := set.Entries(name)
+		if err != nil {
+			return err
+		}
+
+		if entries == nil {
+			entries = []ipset.Entry{}
+		}
+		listings = append(listings, listing{Stats: stats, Members: entries})
+	}
+
+	if asJSON {
+		return printJSON(listings)
+	}
+
+	for i, l := range listings {
+		stats := l.Stats
+
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Name: %s\n", stats.Name)
+		fmt.Printf("Type: %s\n", stats.Type)
+		fmt.Printf("References: %d\n", stats.References)
+		fmt.Printf("Number of entries: %d\n", stats.Entries)
+		fmt.Println("Members:")
+		for _, e := range l.Members {
+			fmt.Println(e)
+		}
+	}
+
+	return nil
+}
+
+func save(set *ipset.IPSet, args []string) error {
+	if len(args) > 1 {
+		return usageError("save takes at most one set name")
+	}
+
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+
+	out, err := set.Save(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(out)
+	return nil
+}
+
+func info(set *ipset.IPSet, args []string, asJSON bool) error {
+	if len(args) != 1 {
+		return usageError("info takes a set name")
+	}
+
+	info, err := set.Info(args[0])
+	if err != nil {
+		return err
+	}
+	stats, err := set.Stats(args[0])
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		return printJSON(struct {
+			Info  ipset.Info  `json:"info"`
+			Stats ipset.Stats `json:"stats"`
+		}{info, stats})
+	}
+
+	fmt.Printf("Name: %s\n", info.Name)
+	fmt.Printf("Type: %s\n", info.Type)
+	fmt.Printf("Revision: %d\n", stats.Revision)
+	fmt.Printf("Definition: %s\n", info)
+	fmt.Printf("Size in memory: %d\n", stats.MemSize)
+	fmt.Printf("References: %d\n", stats.References)
+	fmt.Printf("Number of entries: %d\n", stats.Entries)
+
+	return nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
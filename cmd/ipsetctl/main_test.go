@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/tancred/ipset"
+)
+
+func TestCreateOptions(t *testing.T) {
+	opts, err := createOptions([]string{"hash:net", "family", "inet6", "timeout", "600", "maxelem", "1024", "counters", "comment"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info := ipset.Info{}
+	for _, o := range opts {
+		info = o(info)
+	}
+
+	if info.Type != "hash:net" {
+		t.Errorf("expected type 'hash:net', was '%s'", info.Type)
+	}
+	if info.Family != "inet6" {
+		t.Errorf("expected family 'inet6', was '%s'", info.Family)
+	}
+	if info.Timeout == nil || *info.Timeout != 600 {
+		t.Errorf("expected timeout 600, was %v", info.Timeout)
+	}
+	if info.MaxElem == nil || *info.MaxElem != 1024 {
+		t.Errorf("expected maxelem 1024, was %v", info.MaxElem)
+	}
+	if !info.Counters || !info.Comment {
+		t.Errorf("expected counters and comment, was %v", info)
+	}
+}
+
+func TestCreateOptionsRange(t *testing.T) {
+	for _, c := range []struct {
+		arg  string
+		port bool
+	}{
+		{"0-1024", true},
+		{"10.0.0.0/16", false},
+		{"10.0.0.1-10.0.0.9", false},
+	} {
+		opts, err := createOptions([]string{"bitmap:ip", "range", c.arg})
+		if err != nil {
+			t.Errorf("range %s: unexpected error %v", c.arg, err)
+			continue
+		}
+
+		info := ipset.Info{}
+		for _, o := range opts {
+			info = o(info)
+		}
+
+		if c.port && info.PortRange == nil {
+			t.Errorf("range %s: expected port range", c.arg)
+		}
+		if !c.port && info.IPRange == nil {
+			t.Errorf("range %s: expected address range", c.arg)
+		}
+	}
+}
+
+func TestCreateOptionsInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"hash:ip", "timeout"},
+		{"hash:ip", "timeout", "soon"},
+		{"hash:ip", "colour", "blue"},
+	} {
+		_, err := createOptions(args)
+
+		var uerr usageError
+		if !errors.As(err, &uerr) {
+			t.Errorf("args %v: expected usage error, was %v", args, err)
+		}
+	}
+}
+
+func TestEntryOptions(t *testing.T) {
+	opts, err := entryOptions([]string{"timeout", "30", "comment", "from feed", "nomatch"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entry := ipset.Entry{Element: element("10.0.0.0/8")}
+	for _, o := range opts {
+		entry = o(entry)
+	}
+
+	expected := `10.0.0.0/8 timeout 30 comment "from feed" nomatch`
+	if s := entry.String(); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+
+	if _, err := entryOptions([]string{"packets", "1"}); err == nil {
+		t.Errorf("expected error on unknown option, got nothing")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if c := exitCode(errors.Join(errors.New("x"), ipset.ErrSetNotFound)); c != exitNotFound {
+		t.Errorf("expected %d for missing set, was %d", exitNotFound, c)
+	}
+	if c := exitCode(errors.Join(errors.New("x"), ipset.ErrSetExists)); c != exitExists {
+		t.Errorf("expected %d for existing set, was %d", exitExists, c)
+	}
+	if c := exitCode(errors.New("x")); c != exitError {
+		t.Errorf("expected %d for other errors, was %d", exitError, c)
+	}
+}
+
+func TestListingJSON(t *testing.T) {
+	l := listing{
+		Stats:   ipset.Stats{Name: "bl", Type: "hash:ip", Header: ipset.Info{Name: "bl", Type: "hash:ip"}, Entries: 1},
+		Members: []ipset.Entry{{Element: element("1.2.3.4")}},
+	}
+
+	data, err := json.Marshal(l)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+
+	expected := `{"name":"bl","type":"hash:ip","revision":0,"header":{"name":"bl","type":"hash:ip"},"memory_bytes":0,"references":0,"entries":1,"members":[{"element":"1.2.3.4"}]}`
+	if string(data) != expected {
+		t.Errorf("expected %s, was %s", expected, data)
+	}
+}
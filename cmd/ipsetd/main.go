@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tancred/ipset"
+)
+
+func main() {
+	// Anyone who can reach the listen address can read, flush, swap, add
+	// to and delete from every set unless a token is required. Without
+	// one, only loopback addresses are accepted.
+	listen := flag.String("listen", "127.0.0.1:8080", "listen on `address`; non-loopback addresses need -token-file")
+	tokenFile := flag.String("token-file", "", "require the bearer token in `path` for every request")
+	netns := flag.String("netns", "", "manage the sets of the network namespace at `path`")
+	flag.Parse()
+
+	token, err := readToken(*tokenFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if token == "" && !isLoopback(*listen) {
+		log.Fatalf("refusing to listen on %s without -token-file", *listen)
+	}
+
+	var set *ipset.IPSet
+	if *netns != "" {
+		var err error
+		set, err = ipset.NewNetNS(*netns)
+		if err != nil {
+			log.Fatalf("can't enter network namespace %s: %v", *netns, err)
+		}
+	} else {
+		set = ipset.New()
+	}
+	defer set.Close()
+
+	if err := set.Preflight(); err != nil {
+		log.Fatal(err)
+	}
+
+	handler := NewHandler(set)
+	if token != "" {
+		handler = RequireToken(handler, token)
+	}
+
+	// Timeouts keep slow clients from holding connections open.
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      time.Minute,
+	}
+
+	log.Printf("listening on %s", *listen)
+	log.Fatal(server.ListenAndServe())
+}
+
+// readToken returns the token in path, or none if path is empty.
+func readToken(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// isLoopback reports whether addr only listens on loopback interfaces.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/tancred/ipset"
+)
+
+// Backend is the part of *ipset.IPSet the server uses.
+type Backend interface {
+	Names() ([]string, error)
+	Info(name string) (ipset.Info, error)
+	Stats(name string) (ipset.Stats, error)
+	Entries(name string) ([]ipset.Entry, error)
+	AddElement(name string, elem ipset.Element, options ...ipset.EntryOption) (bool, error)
+	DelElement(name string, elem ipset.Element) (bool, error)
+	TestElement(name string, elem ipset.Element, options ...ipset.EntryOption) (bool, error)
+	Flush(name string) error
+	Swap(from, to string) error
+}
+
+// element is an element taken from a request, passed on verbatim once it
+// has been validated.
+type element string
+
+func (e element) String() string {
+	return string(e)
+}
+
+// maxRequestSize bounds request bodies, which are small JSON objects.
+const maxRequestSize = 64 << 10
+
+type addRequest struct {
+	Element string  `json:"element"`
+	Timeout *int    `json:"timeout"`
+	Comment *string `json:"comment"`
+	NoMatch bool    `json:"nomatch"`
+}
+
+type swapRequest struct {
+	With string `json:"with"`
+}
+
+type infoResponse struct {
+	Info  ipset.Info  `json:"info"`
+	Stats ipset.Stats `json:"stats"`
+}
+
+type testResponse struct {
+	Element string `json:"element"`
+	Member  bool   `json:"member"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type badRequest string
+
+func (e badRequest) Error() string {
+	return string(e)
+}
+
+type server struct {
+	backend Backend
+}
+
+// NewHandler returns the REST API:
+//
+//	GET    /sets                          names of all sets
+//	GET    /sets/{name}                   definition and statistics
+//	POST   /sets/{name}/flush             remove all members
+//	POST   /sets/{name}/swap              swap contents with {"with": name}
+//	GET    /sets/{name}/members           all members
+//	POST   /sets/{name}/members           add {"element", "timeout", "comment", "nomatch"}
+//	GET    /sets/{name}/members/{element} test membership
+//	DELETE /sets/{name}/members/{element} delete a member
+//
+// Elements in paths may contain slashes, e.g. 10.0.0.0/8.
+func NewHandler(backend Backend) http.Handler {
+	s := &server{backend: backend}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /sets", s.handle(s.listSets))
+	mux.HandleFunc("GET /sets/{name}", s.handle(s.getSet))
+	mux.HandleFunc("POST /sets/{name}/flush", s.handle(s.flushSet))
+	mux.HandleFunc("POST /sets/{name}/swap", s.handle(s.swapSet))
+	mux.HandleFunc("GET /sets/{name}/members", s.handle(s.listMembers))
+	mux.HandleFunc("POST /sets/{name}/members", s.handle(s.addMember))
+	mux.HandleFunc("GET /sets/{name}/members/{element...}", s.handle(s.testMember))
+	mux.HandleFunc("DELETE /sets/{name}/members/{element...}", s.handle(s.delMember))
+
+	return mux
+}
+
+// RequireToken wraps h so that every request must carry
+// "Authorization: Bearer token". Listing sets reveals their members, so
+// reads need it as much as changes.
+func RequireToken(h http.Handler, token string) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(errorResponse{Error: "missing or wrong token"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// handle adapts a handler returning a response body or an error. Errors
+// are mapped to a status code and reported as {"error": message}.
+func (s *server) handle(h func(r *http.Request) (int, any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+		status, body, err := h(r)
+		if err != nil {
+			status = errorStatus(err)
+			body = errorResponse{Error: err.Error()}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if body != nil {
+			json.NewEncoder(w).Encode(body)
+		}
+	}
+}
+
+func errorStatus(err error) int {
+	var berr badRequest
+	switch {
+	case errors.As(err, &berr), errors.Is(err, ipset.ErrInvalidElement), errors.Is(err, ipset.ErrElementOutOfRange):
+		return http.StatusBadRequest
+	case errors.Is(err, ipset.ErrSetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ipset.ErrSetExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *server) listSets(r *http.Request) (int, any, error) {
+	names, err := s.backend.Names()
+	if names == nil {
+		names = []string{}
+	}
+	return http.StatusOK, names, err
+}
+
+func (s *server) getSet(r *http.Request) (int, any, error) {
+	name, err := setName(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	info, err := s.backend.Info(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	stats, err := s.backend.Stats(name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusOK, infoResponse{Info: info, Stats: stats}, nil
+}
+
+func (s *server) flushSet(r *http.Request) (int, any, error) {
+	name, err := setName(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusNoContent, nil, s.backend.Flush(name)
+}
+
+func (s *server) swapSet(r *http.Request) (int, any, error) {
+	name, err := setName(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var req swapRequest
+	if err := decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+	if err := validateName(req.With); err != nil {
+		return 0, nil, err
+	}
+
+	return http.StatusNoContent, nil, s.backend.Swap(name, req.With)
+}
+
+func (s *server) listMembers(r *http.Request) (int, any, error) {
+	name, err := setName(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	entries, err := s.backend.Entries(name)
+	if entries == nil {
+		entries = []ipset.Entry{}
+	}
+	return http.StatusOK, entries, err
+}
+
+func (s *server) addMember(r *http.Request) (int, any, error) {
+	name, err := setName(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var req addRequest
+	if err := decode(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	var opts []ipset.EntryOption
+	if req.Timeout != nil {
+		opts = append(opts, ipset.EntryOptionTimeout(*req.Timeout))
+	}
+	if req.Comment != nil {
+		opts = append(opts, ipset.EntryOptionComment(*req.Comment))
+	}
+	if req.NoMatch {
+		opts = append(opts, ipset.EntryOptionNoMatch())
+	}
+
+	elem := element(req.Element)
+	if err := ipset.ValidateElement(elem, opts...); err != nil {
+		return 0, nil, err
+	}
+
+	_, err = s.backend.AddElement(name, elem, opts...)
+	return http.StatusNoContent, nil, err
+}
+
+func (s *server) testMember(r *http.Request) (int, any, error) {
+	name, elem, err := setNameAndElement(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	found, err := s.backend.TestElement(name, elem)
+	return http.StatusOK, testResponse{Element: elem.String(), Member: found}, err
+}
+
+func (s *server) delMember(r *http.Request) (int, any, error) {
+	name, elem, err := setNameAndElement(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	_, err = s.backend.DelElement(name, elem)
+	return http.StatusNoContent, nil, err
+}
+
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return badRequest(fmt.Sprintf("invalid request body: %v", err))
+	}
+	return nil
+}
+
+func setName(r *http.Request) (string, error) {
+	name := r.PathValue("name")
+	return name, validateName(name)
+}
+
+func setNameAndElement(r *http.Request) (string, element, error) {
+	name, err := setName(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	elem := element(r.PathValue("element"))
+	return name, elem, ipset.ValidateElement(elem)
+}
+
+func validateName(name string) error {
+	if err := ipset.ValidateSetName(name); err != nil {
+		return badRequest(err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tancred/ipset"
+)
+
+// fakeBackend keeps sets as ordered lists of entries.
+type fakeBackend map[string][]ipset.Entry
+
+func (b fakeBackend) Names() ([]string, error) {
+	var names []string
+	for name := range b {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (b fakeBackend) Info(name string) (ipset.Info, error) {
+	if _, ok := b[name]; !ok {
+		return ipset.Info{}, ipset.ErrSetNotFound
+	}
+	return ipset.Info{Name: name, Type: "hash:ip", Family: "inet"}, nil
+}
+
+func (b fakeBackend) Stats(name string) (ipset.Stats, error) {
+	if _, ok := b[name]; !ok {
+		return ipset.Stats{}, ipset.ErrSetNotFound
+	}
+	return ipset.Stats{Name: name, Type: "hash:ip", Entries: len(b[name])}, nil
+}
+
+func (b fakeBackend) Entries(name string) ([]ipset.Entry, error) {
+	entries, ok := b[name]
+	if !ok {
+		return nil, ipset.ErrSetNotFound
+	}
+	return entries, nil
+}
+
+func (b fakeBackend) AddElement(name string, elem ipset.Element, options ...ipset.EntryOption) (bool, error) {
+	if _, ok := b[name]; !ok {
+		return false, ipset.ErrSetNotFound
+	}
+	entry := ipset.Entry{Element: elem}
+	for _, o := range options {
+		entry = o(entry)
+	}
+	b[name] = append(b[name], entry)
+	return true, nil
+}
+
+func (b fakeBackend) DelElement(name string, elem ipset.Element) (bool, error) {
+	entries, ok := b[name]
+	if !ok {
+		return false, ipset.ErrSetNotFound
+	}
+	for i, e := range entries {
+		if e.Element.String() == elem.String() {
+			b[name] = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	return true, nil
+}
+
+func (b fakeBackend) TestElement(name string, elem ipset.Element, options ...ipset.EntryOption) (bool, error) {
+	entries, ok := b[name]
+	if !ok {
+		return false, ipset.ErrSetNotFound
+	}
+	for _, e := range entries {
+		if e.Element.String() == elem.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b fakeBackend) Flush(name string) error {
+	if _, ok := b[name]; !ok {
+		return ipset.ErrSetNotFound
+	}
+	b[name] = nil
+	return nil
+}
+
+func (b fakeBackend) Swap(from, to string) error {
+	if _, ok := b[from]; !ok {
+		return ipset.ErrSetNotFound
+	}
+	if _, ok := b[to]; !ok {
+		return ipset.ErrSetNotFound
+	}
+	b[from], b[to] = b[to], b[from]
+	return nil
+}
+
+func do(t *testing.T, h http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAddTestDelMember(t *testing.T) {
+	backend := fakeBackend{"bl": nil}
+	h := NewHandler(backend)
+
+	rec := do(t, h, "POST", "/sets/bl/members", `{"element":"10.0.0.0/8","timeout":600,"comment":"feed x"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d on add, was %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+
+	entry := backend["bl"][0]
+	if entry.String() != `10.0.0.0/8 timeout 600 comment "feed x"` {
+		t.Errorf("unexpected entry added: %s", entry)
+	}
+
+	rec = do(t, h, "GET", "/sets/bl/members/10.0.0.0/8", "")
+	var res testResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unexpected response %s: %v", rec.Body, err)
+	}
+	if rec.Code != http.StatusOK || !res.Member || res.Element != "10.0.0.0/8" {
+		t.Errorf("expected 10.0.0.0/8 to be a member, was %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(t, h, "DELETE", "/sets/bl/members/10.0.0.0/8", "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("expected status %d on delete, was %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+	if len(backend["bl"]) != 0 {
+		t.Errorf("expected member deleted, was %v", backend["bl"])
+	}
+}
+
+func TestListMembers(t *testing.T) {
+	timeout := 30
+	backend := fakeBackend{"bl": {{Element: element("1.2.3.4"), Timeout: &timeout}}}
+	h := NewHandler(backend)
+
+	rec := do(t, h, "GET", "/sets/bl/members", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, was %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	if s := strings.TrimSpace(rec.Body.String()); s != `[{"element":"1.2.3.4","timeout":30}]` {
+		t.Errorf("unexpected members %s", s)
+	}
+
+	rec = do(t, h, "GET", "/sets", "")
+	if s := strings.TrimSpace(rec.Body.String()); s != `["bl"]` {
+		t.Errorf("unexpected sets %s", s)
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	h := NewHandler(fakeBackend{"bl": nil})
+
+	rec := do(t, h, "GET", "/sets/bl", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, was %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	var res infoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("unexpected response %s: %v", rec.Body, err)
+	}
+	if res.Info.Name != "bl" || res.Info.Type != "hash:ip" {
+		t.Errorf("unexpected info %v", res.Info)
+	}
+}
+
+func TestFlushAndSwap(t *testing.T) {
+	backend := fakeBackend{"a": {{Element: element("1.1.1.1")}}, "b": nil}
+	h := NewHandler(backend)
+
+	rec := do(t, h, "POST", "/sets/a/swap", `{"with":"b"}`)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d on swap, was %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+	if len(backend["a"]) != 0 || len(backend["b"]) != 1 {
+		t.Errorf("expected contents swapped, was %v", backend)
+	}
+
+	rec = do(t, h, "POST", "/sets/b/flush", "")
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d on flush, was %d: %s", http.StatusNoContent, rec.Code, rec.Body)
+	}
+	if len(backend["b"]) != 0 {
+		t.Errorf("expected b flushed, was %v", backend["b"])
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	long := strings.Repeat("x", 32)
+	h := NewHandler(fakeBackend{"bl": nil})
+
+	cases := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"GET", "/sets/nope", "", http.StatusNotFound},
+		{"GET", "/sets/nope/members", "", http.StatusNotFound},
+		{"POST", "/sets/nope/members", `{"element":"1.2.3.4"}`, http.StatusNotFound},
+		{"POST", "/sets/" + long + "/flush", "", http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":""}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"1.2.3.4 timeout 1"}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"1.2.3.4","timeout":-1}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"1.2.3.4","comment":"a\"b"}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"-exist"}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"1.2.3.4,-file"}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"1.2.3.4","comment":"-output"}`, http.StatusBadRequest},
+		{"DELETE", "/sets/bl/members/-exist", "", http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"1.2.3.4","colour":"blue"}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `not json`, http.StatusBadRequest},
+		{"POST", "/sets/bl/members", `{"element":"1.2.3.4"` + strings.Repeat(" ", maxRequestSize) + `}`, http.StatusBadRequest},
+		{"POST", "/sets/bl/swap", `{"with":"nope"}`, http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		rec := do(t, h, c.method, c.path, c.body)
+		if rec.Code != c.status {
+			t.Errorf("%s %s %s: expected status %d, was %d: %s", c.method, c.path, c.body, c.status, rec.Code, rec.Body)
+		}
+	}
+
+	if s := errorStatus(ipset.ErrSetExists); s != http.StatusConflict {
+		t.Errorf("expected status %d for ErrSetExists, was %d", http.StatusConflict, s)
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	h := RequireToken(NewHandler(fakeBackend{"bl": nil}), "s3cret")
+
+	cases := []struct {
+		method string
+		path   string
+		auth   string
+		status int
+	}{
+		{"GET", "/sets/bl/members", "", http.StatusUnauthorized},
+		{"GET", "/sets/bl/members/1.2.3.4", "Bearer wrong", http.StatusUnauthorized},
+		{"GET", "/sets/bl/members", "Bearer s3cret", http.StatusOK},
+		{"POST", "/sets/bl/flush", "", http.StatusUnauthorized},
+		{"POST", "/sets/bl/flush", "Bearer wrong", http.StatusUnauthorized},
+		{"POST", "/sets/bl/flush", "s3cret", http.StatusUnauthorized},
+		{"POST", "/sets/bl/flush", "Bearer s3cret", http.StatusNoContent},
+		{"DELETE", "/sets/bl/members/1.2.3.4", "", http.StatusUnauthorized},
+		{"DELETE", "/sets/bl/members/1.2.3.4", "Bearer s3cret", http.StatusNoContent},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("%s %s %q: expected status %d, was %d", c.method, c.path, c.auth, c.status, rec.Code)
+		}
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1:8080": true,
+		"[::1]:8080":     true,
+		"localhost:8080": true,
+		":8080":          false,
+		"0.0.0.0:8080":   false,
+		"10.0.0.1:8080":  false,
+		"127.0.0.1":      false,
+	}
+
+	for addr, loopback := range cases {
+		if isLoopback(addr) != loopback {
+			t.Errorf("%s: expected loopback %v", addr, loopback)
+		}
+	}
+}
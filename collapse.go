@@ -0,0 +1,228 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+)
+
+// CollapseReport is the result of Collapse.
+type CollapseReport struct {
+	// Prefixes is the smallest list of prefixes matching the same
+	// addresses as the input, IPv4 before IPv6, each in address order.
+	Prefixes []*net.IPNet
+	// Input is the number of elements and exceptions given, Exceptions
+	// the number of nomatch exceptions among them.
+	Input      int
+	Exceptions int
+}
+
+// Output is the number of prefixes left after collapsing.
+func (r CollapseReport) Output() int {
+	return len(r.Prefixes)
+}
+
+// Removed is the number of entries saved by collapsing.
+func (r CollapseReport) Removed() int {
+	return r.Input - r.Output()
+}
+
+func (r CollapseReport) String() string {
+	return fmt.Sprintf("collapsed %d entries (%d exceptions) to %d, %d removed", r.Input, r.Exceptions, r.Output(), r.Removed())
+}
+
+// Elements returns the prefixes as elements, ready for AddElements.
+func (r CollapseReport) Elements() []Element {
+	elems := make([]Element, len(r.Prefixes))
+	for i, n := range r.Prefixes {
+		elems[i] = n
+	}
+	return elems
+}
+
+type CollapseOption func(c collapseConfig) collapseConfig
+
+type collapseConfig struct {
+	exceptions []Element
+}
+
+// CollapseOptionExceptions honours nomatch exceptions the way a hash:net
+// set does: the most specific prefix matching an address decides whether
+// it matches. Exceptions are folded into the result, which contains no
+// nomatch entries. An exception identical to a prefix wins.
+func CollapseOptionExceptions(exceptions []Element) CollapseOption {
+	return func(c collapseConfig) collapseConfig {
+		c.exceptions = exceptions
+		return c
+	}
+}
+
+// Collapse removes duplicates, prefixes covered by other prefixes and
+// merges adjacent prefixes, so that the elements can be loaded into a
+// hash:net set with the fewest entries. Elements must be addresses,
+// prefixes or address ranges; anything else is an ErrInvalidElement.
+func Collapse(elems []Element, options ...CollapseOption) (CollapseReport, error) {
+	var c collapseConfig
+	for _, o := range options {
+		c = o(c)
+	}
+
+	report := CollapseReport{Input: len(elems) + len(c.exceptions), Exceptions: len(c.exceptions)}
+	var v4, v6 prefixTrie
+
+	insert := func(elem Element, nomatch bool) error {
+		nets, err := elementPrefixes(elem)
+		if err != nil {
+			return err
+		}
+		for _, n := range nets {
+			if n.IP.To4() != nil {
+				v4.insert(n, nomatch)
+			} else {
+				v6.insert(n, nomatch)
+			}
+		}
+		return nil
+	}
+
+	for _, elem := range elems {
+		if err := insert(elem, false); err != nil {
+			return CollapseReport{}, err
+		}
+	}
+	for _, elem := range c.exceptions {
+		if err := insert(elem, true); err != nil {
+			return CollapseReport{}, err
+		}
+	}
+
+	var ranges []IPRange
+	for _, n := range append(v4.matching(net.IPv4len), v6.matching(net.IPv6len)...) {
+		ranges = append(ranges, IPRangeFromNet(n))
+	}
+	report.Prefixes = AggregateCIDRs(ranges)
+
+	return report, nil
+}
+
+func elementPrefixes(elem Element) ([]*net.IPNet, error) {
+	switch e := elem.(type) {
+	case net.IP:
+		if ip4 := e.To4(); ip4 != nil {
+			return []*net.IPNet{{IP: ip4, Mask: net.CIDRMask(32, 32)}}, nil
+		} else if ip6 := e.To16(); ip6 != nil {
+			return []*net.IPNet{{IP: ip6, Mask: net.CIDRMask(128, 128)}}, nil
+		}
+	case *net.IPNet:
+		if e != nil {
+			if n := trieNet(e); n != nil {
+				return []*net.IPNet{n}, nil
+			}
+		}
+	case IPRange:
+		if err := e.Validate(); err != nil {
+			return nil, err
+		}
+		var nets []*net.IPNet
+		for _, n := range e.CIDRs() {
+			nets = append(nets, trieNet(n))
+		}
+		return nets, nil
+	}
+	return nil, fmt.Errorf("can't collapse %v: %w", elem, ErrInvalidElement)
+}
+
+// trieNet returns n with an address and mask of the same length, 4 bytes
+// for IPv4 and IPv4-mapped prefixes such as ::ffff:10.0.0.0/120 and 16
+// bytes otherwise, or nil if n is neither.
+func trieNet(n *net.IPNet) *net.IPNet {
+	ones, bits := n.Mask.Size()
+	ip4 := n.IP.To4()
+
+	switch {
+	case ip4 == nil && bits == 8*net.IPv6len && n.IP.To16() != nil:
+		return &net.IPNet{IP: n.IP.To16(), Mask: n.Mask}
+	case ip4 != nil && bits == 8*net.IPv4len:
+		return &net.IPNet{IP: ip4, Mask: n.Mask}
+	case ip4 != nil && bits == 8*net.IPv6len && ones >= 96:
+		mask := net.CIDRMask(ones-96, 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+	return nil
+}
+
+// prefixTrie is a binary trie of prefixes, each marked as matching or as a
+// nomatch exception.
+type prefixTrie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	child   [2]*trieNode
+	set     bool
+	nomatch bool
+}
+
+// insert adds n, which must have an address and mask of the same length.
+func (t *prefixTrie) insert(n *net.IPNet, nomatch bool) {
+	ip := n.IP
+	ones, _ := n.Mask.Size()
+
+	if t.root == nil {
+		t.root = &trieNode{}
+	}
+	node := t.root
+	for i := 0; i < ones; i++ {
+		b := ipBit(ip, i)
+		if node.child[b] == nil {
+			node.child[b] = &trieNode{}
+		}
+		node = node.child[b]
+	}
+
+	if !node.set || nomatch {
+		node.set = true
+		node.nomatch = nomatch
+	}
+}
+
+// matching returns disjoint prefixes covering the addresses whose most
+// specific prefix in the trie is not an exception.
+func (t *prefixTrie) matching(size int) []*net.IPNet {
+	var nets []*net.IPNet
+	var walk func(node *trieNode, ip net.IP, depth int, match bool)
+
+	walk = func(node *trieNode, ip net.IP, depth int, match bool) {
+		if node.set {
+			match = !node.nomatch
+		}
+		if node.child[0] == nil && node.child[1] == nil {
+			if match {
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(depth, size*8)})
+			}
+			return
+		}
+
+		for b, child := range node.child {
+			half := make(net.IP, size)
+			copy(half, ip)
+			if b == 1 {
+				half[depth/8] |= 0x80 >> (depth % 8)
+			}
+
+			if child != nil {
+				walk(child, half, depth+1, match)
+			} else if match {
+				nets = append(nets, &net.IPNet{IP: half, Mask: net.CIDRMask(depth+1, size*8)})
+			}
+		}
+	}
+
+	if t.root != nil {
+		walk(t.root, make(net.IP, size), 0, false)
+	}
+	return nets
+}
+
+func ipBit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-i%8)) & 1
+}
@@ -0,0 +1,155 @@
+package ipset
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestCollapse(t *testing.T) {
+	elems := []Element{mustParseCIDR(t, "192.0.2.0/24")}
+	for i := 0; i < 256; i++ {
+		elems = append(elems, net.IPv4(192, 0, 2, byte(i)).To4())
+	}
+	elems = append(elems,
+		mustParseCIDR(t, "198.51.100.0/25"),
+		mustParseCIDR(t, "198.51.100.128/25"),
+		mustParseRange("203.0.113.0-203.0.113.255"),
+		net.ParseIP("2001:db8::1"),
+		mustParseCIDR(t, "2001:db8::/64"),
+	)
+
+	report, err := Collapse(elems)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := "192.0.2.0/24 198.51.100.0/24 203.0.113.0/24 2001:db8::/64"
+	if s := netStrings(report.Prefixes); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+	if report.Input != 262 || report.Output() != 4 || report.Removed() != 258 {
+		t.Errorf("unexpected report %s", report)
+	}
+}
+
+func TestCollapseExceptions(t *testing.T) {
+	elems := []Element{
+		mustParseCIDR(t, "10.0.0.0/24"),
+		mustParseCIDR(t, "10.0.0.128/26"),
+		net.ParseIP("10.0.0.200"),
+	}
+	exceptions := []Element{
+		mustParseCIDR(t, "10.0.0.128/25"),
+		mustParseCIDR(t, "10.1.0.0/16"),
+	}
+
+	report, err := Collapse(elems, CollapseOptionExceptions(exceptions))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The /26 and the single address are more specific than the /25
+	// exception and so still match.
+	expected := "10.0.0.0/25 10.0.0.128/26 10.0.0.200/32"
+	if s := netStrings(report.Prefixes); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+	if report.Input != 5 || report.Exceptions != 2 {
+		t.Errorf("unexpected report %s", report)
+	}
+}
+
+func TestCollapseMapped(t *testing.T) {
+	_, mapped, _ := net.ParseCIDR("::ffff:10.0.0.0/120")
+
+	report, err := Collapse([]Element{mapped, mustParseCIDR(t, "10.0.1.0/24")})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := "10.0.0.0/23"
+	if s := netStrings(report.Prefixes); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+}
+
+func TestCollapseInvalid(t *testing.T) {
+	_, err := Collapse([]Element{Port(80)})
+	if !errors.Is(err, ErrInvalidElement) {
+		t.Errorf("error should be ErrInvalidElement, was %v", err)
+	}
+
+	_, err = Collapse([]Element{&net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(64, 128)}})
+	if !errors.Is(err, ErrInvalidElement) {
+		t.Errorf("error should be ErrInvalidElement, was %v", err)
+	}
+
+	_, err = Collapse(nil, CollapseOptionExceptions([]Element{rawRange("10.0.0.2-10.0.0.1")}))
+	if !errors.Is(err, ErrInvalidElement) {
+		t.Errorf("error should be ErrInvalidElement, was %v", err)
+	}
+}
+
+// TestCollapseMatchesSameAddresses checks random prefixes and exceptions
+// within a /22 against the most specific match of every address.
+func TestCollapseMatchesSameAddresses(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	randomPrefix := func() *net.IPNet {
+		ones := 22 + rnd.Intn(11)
+		ip := net.IPv4(10, 0, byte(rnd.Intn(4)), byte(rnd.Intn(256))).To4()
+		return &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)}
+	}
+
+	for round := 0; round < 200; round++ {
+		var elems, exceptions []Element
+		var prefixes, nomatch []*net.IPNet
+		for i := rnd.Intn(20); i >= 0; i-- {
+			n := randomPrefix()
+			elems = append(elems, n)
+			prefixes = append(prefixes, n)
+		}
+		for i := rnd.Intn(5); i > 0; i-- {
+			n := randomPrefix()
+			exceptions = append(exceptions, n)
+			nomatch = append(nomatch, n)
+		}
+
+		report, err := Collapse(elems, CollapseOptionExceptions(exceptions))
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if !isMinimal(report.Prefixes) {
+			t.Fatalf("not minimal: %s", netStrings(report.Prefixes))
+		}
+
+		for a := 0; a < 1024; a++ {
+			ip := net.IPv4(10, 0, byte(a/256), byte(a%256)).To4()
+
+			expected := false
+			best := -1
+			for _, n := range prefixes {
+				if ones, _ := n.Mask.Size(); n.Contains(ip) && ones > best {
+					expected, best = true, ones
+				}
+			}
+			for _, n := range nomatch {
+				if ones, _ := n.Mask.Size(); n.Contains(ip) && ones >= best {
+					expected, best = false, ones
+				}
+			}
+
+			matched := 0
+			for _, n := range report.Prefixes {
+				if n.Contains(ip) {
+					matched++
+				}
+			}
+			if matched > 1 || (matched == 1) != expected {
+				t.Fatalf("%s: expected match %v, was matched by %d of %s", ip, expected, matched, netStrings(report.Prefixes))
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Package collector exports the state of ipsets as Prometheus metrics. It
+// is a module of its own so that the library does not depend on Prometheus.
+package collector
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tancred/ipset"
+)
+
+// Lister is the part of *ipset.IPSet the collector reads from.
+type Lister interface {
+	Names() ([]string, error)
+	Stats(name string) (ipset.Stats, error)
+	Entries(name string) ([]ipset.Entry, error)
+}
+
+var (
+	entriesDesc = prometheus.NewDesc(
+		"ipset_entries",
+		"Number of entries in the set.",
+		[]string{"set", "type"}, nil)
+	memoryDesc = prometheus.NewDesc(
+		"ipset_memory_bytes",
+		"Memory used by the set in the kernel.",
+		[]string{"set"}, nil)
+	referencesDesc = prometheus.NewDesc(
+		"ipset_references",
+		"Number of references to the set, e.g. from iptables rules.",
+		[]string{"set"}, nil)
+	maxElemDesc = prometheus.NewDesc(
+		"ipset_maxelem",
+		"Maximal number of entries the set can hold.",
+		[]string{"set"}, nil)
+	utilisationDesc = prometheus.NewDesc(
+		"ipset_maxelem_utilisation_ratio",
+		"Number of entries divided by maxelem.",
+		[]string{"set"}, nil)
+	packetsDesc = prometheus.NewDesc(
+		"ipset_entry_packets_total",
+		"Packets matched by the entry.",
+		[]string{"set", "entry"}, nil)
+	bytesDesc = prometheus.NewDesc(
+		"ipset_entry_bytes_total",
+		"Bytes matched by the entry.",
+		[]string{"set", "entry"}, nil)
+)
+
+type config struct {
+	sets          []string
+	entryCounters bool
+}
+
+type Option func(c config) config
+
+// OptionSets limits the collector to the named sets. By default every set
+// is collected.
+func OptionSets(names ...string) Option {
+	return func(c config) config {
+		c.sets = names
+		return c
+	}
+}
+
+// OptionEntryCounters enables per-entry packet and byte counters for sets
+// created with counters. Every entry becomes a time series, so only use it
+// for small sets.
+func OptionEntryCounters() Option {
+	return func(c config) config {
+		c.entryCounters = true
+		return c
+	}
+}
+
+type Collector struct {
+	lister Lister
+	config config
+}
+
+func New(lister Lister, options ...Option) *Collector {
+	c := config{}
+	for _, o := range options {
+		c = o(c)
+	}
+
+	return &Collector{lister: lister, config: c}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- entriesDesc
+	ch <- memoryDesc
+	ch <- referencesDesc
+	ch <- maxElemDesc
+	ch <- utilisationDesc
+	if c.config.entryCounters {
+		ch <- packetsDesc
+		ch <- bytesDesc
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	names := c.config.sets
+	if names == nil {
+		var err error
+		names, err = c.lister.Names()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(entriesDesc, err)
+			return
+		}
+	}
+
+	for _, name := range names {
+		c.collectSet(ch, name)
+	}
+}
+
+// collectSet sends the metrics of the named set. A set destroyed since it
+// was listed is skipped rather than failing the scrape.
+func (c *Collector) collectSet(ch chan<- prometheus.Metric, name string) {
+	stats, err := c.lister.Stats(name)
+	if errors.Is(err, ipset.ErrSetNotFound) {
+		return
+	}
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(entriesDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(stats.Entries), name, string(stats.Type))
+	ch <- prometheus.MustNewConstMetric(memoryDesc, prometheus.GaugeValue, float64(stats.MemSize), name)
+	ch <- prometheus.MustNewConstMetric(referencesDesc, prometheus.GaugeValue, float64(stats.References), name)
+
+	if maxelem := stats.Header.MaxElem; maxelem != nil && *maxelem > 0 {
+		ch <- prometheus.MustNewConstMetric(maxElemDesc, prometheus.GaugeValue, float64(*maxelem), name)
+		ch <- prometheus.MustNewConstMetric(utilisationDesc, prometheus.GaugeValue, stats.Utilisation(), name)
+	}
+
+	if !c.config.entryCounters || !stats.Header.Counters {
+		return
+	}
+
+	entries, err := c.lister.Entries(name)
+	if errors.Is(err, ipset.ErrSetNotFound) {
+		return
+	}
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(packetsDesc, err)
+		return
+	}
+
+	for _, e := range entries {
+		elem := e.Element.String()
+		if e.Packets != nil {
+			ch <- prometheus.MustNewConstMetric(packetsDesc, prometheus.CounterValue, float64(*e.Packets), name, elem)
+		}
+		if e.Bytes != nil {
+			ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.CounterValue, float64(*e.Bytes), name, elem)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package collector
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tancred/ipset"
+)
+
+type fakeSet struct {
+	stats   ipset.Stats
+	entries []ipset.Entry
+}
+
+type fakeLister map[string]fakeSet
+
+func (l fakeLister) Names() ([]string, error) {
+	var names []string
+	for name := range l {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (l fakeLister) Stats(name string) (ipset.Stats, error) {
+	s, ok := l[name]
+	if !ok {
+		return ipset.Stats{}, ipset.ErrSetNotFound
+	}
+	return s.stats, nil
+}
+
+func (l fakeLister) Entries(name string) ([]ipset.Entry, error) {
+	s, ok := l[name]
+	if !ok {
+		return nil, ipset.ErrSetNotFound
+	}
+	return s.entries, nil
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func uint64Ptr(n uint64) *uint64 {
+	return &n
+}
+
+func testLister() fakeLister {
+	return fakeLister{
+		"bl": {
+			stats: ipset.Stats{
+				Name:       "bl",
+				Type:       "hash:ip",
+				Header:     ipset.Info{Name: "bl", Type: "hash:ip", MaxElem: intPtr(1000), Counters: true},
+				MemSize:    504,
+				References: 1,
+				Entries:    2,
+			},
+			entries: []ipset.Entry{
+				{Element: net.IPv4(10, 0, 0, 1), Packets: uint64Ptr(7), Bytes: uint64Ptr(420)},
+				{Element: net.IPv4(10, 0, 0, 2), Packets: uint64Ptr(0), Bytes: uint64Ptr(0)},
+			},
+		},
+		"lst": {
+			stats: ipset.Stats{
+				Name:       "lst",
+				Type:       "list:set",
+				Header:     ipset.Info{Name: "lst", Type: "list:set", Size: intPtr(8)},
+				MemSize:    88,
+				References: 0,
+				Entries:    1,
+			},
+		},
+	}
+}
+
+func TestCollector(t *testing.T) {
+	expected := `
+# HELP ipset_entries Number of entries in the set.
+# TYPE ipset_entries gauge
+ipset_entries{set="bl",type="hash:ip"} 2
+ipset_entries{set="lst",type="list:set"} 1
+# HELP ipset_maxelem Maximal number of entries the set can hold.
+# TYPE ipset_maxelem gauge
+ipset_maxelem{set="bl"} 1000
+# HELP ipset_maxelem_utilisation_ratio Number of entries divided by maxelem.
+# TYPE ipset_maxelem_utilisation_ratio gauge
+ipset_maxelem_utilisation_ratio{set="bl"} 0.002
+# HELP ipset_memory_bytes Memory used by the set in the kernel.
+# TYPE ipset_memory_bytes gauge
+ipset_memory_bytes{set="bl"} 504
+ipset_memory_bytes{set="lst"} 88
+# HELP ipset_references Number of references to the set, e.g. from iptables rules.
+# TYPE ipset_references gauge
+ipset_references{set="bl"} 1
+ipset_references{set="lst"} 0
+`
+
+	err := testutil.CollectAndCompare(New(testLister()), strings.NewReader(expected))
+	if err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
+
+func TestCollectorEntryCounters(t *testing.T) {
+	expected := `
+# HELP ipset_entry_bytes_total Bytes matched by the entry.
+# TYPE ipset_entry_bytes_total counter
+ipset_entry_bytes_total{entry="10.0.0.1",set="bl"} 420
+ipset_entry_bytes_total{entry="10.0.0.2",set="bl"} 0
+# HELP ipset_entry_packets_total Packets matched by the entry.
+# TYPE ipset_entry_packets_total counter
+ipset_entry_packets_total{entry="10.0.0.1",set="bl"} 7
+ipset_entry_packets_total{entry="10.0.0.2",set="bl"} 0
+`
+
+	c := New(testLister(), OptionSets("bl"), OptionEntryCounters())
+	err := testutil.CollectAndCompare(c, strings.NewReader(expected), "ipset_entry_packets_total", "ipset_entry_bytes_total")
+	if err != nil {
+		t.Errorf("unexpected metrics: %v", err)
+	}
+}
+
+// vanishingLister lists a set that is gone by the time it is read.
+type vanishingLister struct {
+	fakeLister
+}
+
+func (l vanishingLister) Names() ([]string, error) {
+	names, err := l.fakeLister.Names()
+	return append(names, "gone"), err
+}
+
+// failingLister fails to read the stats of every set.
+type failingLister struct {
+	fakeLister
+}
+
+func (l failingLister) Stats(name string) (ipset.Stats, error) {
+	return ipset.Stats{}, errors.New("netlink receive: connection refused")
+}
+
+func TestCollectorMissingSet(t *testing.T) {
+	for _, c := range []*Collector{New(testLister(), OptionSets("nope")), New(vanishingLister{testLister()})} {
+		if _, err := testutil.CollectAndLint(c); err != nil {
+			t.Errorf("missing sets should be skipped, was %v", err)
+		}
+	}
+
+	if n := testutil.CollectAndCount(New(vanishingLister{testLister()}), "ipset_entries"); n != 2 {
+		t.Errorf("expected entries of 2 sets, was %d", n)
+	}
+}
+
+func TestCollectorFailure(t *testing.T) {
+	_, err := testutil.CollectAndLint(New(failingLister{testLister()}))
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected the failure to be reported, was %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package ipset
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// InfoDiff is a difference in a single create parameter between a desired
+// and an actual set definition. Recreate is set when the set has to be
+// created anew to fix it; the rest can be dealt with in place.
+type InfoDiff struct {
+	Field    string
+	Desired  string
+	Actual   string
+	Recreate bool
+}
+
+func (d InfoDiff) String() string {
+	return fmt.Sprintf("%s: expected %s but was %s", d.Field, d.Desired, d.Actual)
+}
+
+// DiffInfo compares desired against actual across every create parameter.
+// Type and family default as they do for Create, and the family is not
+// compared for types that take none. Optional parameters left
+// unset in desired are taken to mean "whatever the kernel picked" and are
+// not compared, except for the timeout whose absence is significant. The
+// flags (counters, comment, skbinfo, forceadd) are always compared.
+//
+// A differing name is fixed by renaming the set and a differing hashsize
+// needs no fixing at all since the kernel resizes hashes as they fill up.
+// Every other parameter is fixed at creation.
+func DiffInfo(desired Info, actual Info) []InfoDiff {
+	desired = withDefaults(desired)
+
+	var diffs []InfoDiff
+	cmp := func(field string, d string, a string, recreate bool) {
+		if d != a {
+			diffs = append(diffs, InfoDiff{Field: field, Desired: d, Actual: a, Recreate: recreate})
+		}
+	}
+	cmpOpt := func(field string, set bool, d string, a string, recreate bool) {
+		if set {
+			cmp(field, d, a, recreate)
+		}
+	}
+
+	cmp("name", desired.Name, actual.Name, false)
+	cmp("type", string(desired.Type), string(actual.Type), true)
+	cmpOpt("family", typeHasFamily(desired.Type), string(desired.Family), string(actual.Family), true)
+	cmp("timeout", fmtIntPtr(desired.Timeout), fmtIntPtr(actual.Timeout), true)
+	cmpOpt("size", desired.Size != nil, fmtIntPtr(desired.Size), fmtIntPtr(actual.Size), true)
+	cmpOpt("range", desired.IPRange != nil, fmtPtr(desired.IPRange), fmtPtr(actual.IPRange), true)
+	cmpOpt("range", desired.PortRange != nil, fmtPtr(desired.PortRange), fmtPtr(actual.PortRange), true)
+	cmpOpt("netmask", desired.Netmask != nil, fmtIntPtr(desired.Netmask), fmtIntPtr(actual.Netmask), true)
+	cmpOpt("bitmask", desired.Bitmask != nil, fmtPtr(desired.Bitmask), fmtPtr(actual.Bitmask), true)
+	cmpOpt("markmask", desired.MarkMask != nil, fmtMarkPtr(desired.MarkMask), fmtMarkPtr(actual.MarkMask), true)
+	cmpOpt("hashsize", desired.HashSize != nil, fmtIntPtr(desired.HashSize), fmtIntPtr(actual.HashSize), false)
+	cmpOpt("maxelem", desired.MaxElem != nil, fmtIntPtr(desired.MaxElem), fmtIntPtr(actual.MaxElem), true)
+	cmpOpt("bucketsize", desired.BucketSize != nil, fmtIntPtr(desired.BucketSize), fmtIntPtr(actual.BucketSize), true)
+	cmpOpt("initval", desired.InitVal != nil, fmtMarkPtr(desired.InitVal), fmtMarkPtr(actual.InitVal), true)
+	cmp("counters", strconv.FormatBool(desired.Counters), strconv.FormatBool(actual.Counters), true)
+	cmp("comment", strconv.FormatBool(desired.Comment), strconv.FormatBool(actual.Comment), true)
+	cmp("skbinfo", strconv.FormatBool(desired.SkbInfo), strconv.FormatBool(actual.SkbInfo), true)
+	cmp("forceadd", strconv.FormatBool(desired.ForceAdd), strconv.FormatBool(actual.ForceAdd), true)
+
+	return diffs
+}
+
+// RequiresRecreate reports whether any of diffs can only be fixed by
+// recreating the set.
+func RequiresRecreate(diffs []InfoDiff) bool {
+	for _, d := range diffs {
+		if d.Recreate {
+			return true
+		}
+	}
+	return false
+}
+
+func fmtIntPtr(p *int) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return strconv.Itoa(*p)
+}
+
+func fmtMarkPtr(p *uint32) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("0x%08x", *p)
+}
+
+func fmtPtr[T fmt.Stringer](p *T) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return (*p).String()
+}
@@ -0,0 +1,78 @@
+package ipset
+
+import (
+	"testing"
+)
+
+func TestDiffInfo(t *testing.T) {
+	timeout := 600
+	otherTimeout := 300
+	hashsize := 4096
+	actualHashsize := 1024
+	maxelem := 65536
+
+	actual := Info{
+		Name:     "bl",
+		Type:     "hash:ip",
+		Family:   "inet",
+		Timeout:  &timeout,
+		HashSize: &actualHashsize,
+		MaxElem:  &maxelem,
+	}
+
+	cases := []struct {
+		desired  Info
+		fields   []string
+		recreate bool
+	}{
+		{Info{Name: "bl", Timeout: &timeout}, nil, false},
+		{Info{Name: "bl", Type: "hash:ip", Family: "inet", Timeout: &timeout, MaxElem: &maxelem}, nil, false},
+		{Info{Name: "bl"}, []string{"timeout"}, true},
+		{Info{Name: "bl", Timeout: &otherTimeout}, []string{"timeout"}, true},
+		{Info{Name: "bl2", Timeout: &timeout}, []string{"name"}, false},
+		{Info{Name: "bl", Timeout: &timeout, HashSize: &hashsize}, []string{"hashsize"}, false},
+		{Info{Name: "bl", Family: "inet6", Timeout: &timeout}, []string{"family"}, true},
+		{Info{Name: "bl", Type: "hash:net", Timeout: &timeout, Counters: true}, []string{"type", "counters"}, true},
+	}
+
+	for _, c := range cases {
+		diffs := DiffInfo(c.desired, actual)
+
+		if len(diffs) != len(c.fields) {
+			t.Errorf("desired %v: expected diffs in %v, was %v", c.desired, c.fields, diffs)
+			continue
+		}
+		for i, d := range diffs {
+			if d.Field != c.fields[i] {
+				t.Errorf("desired %v: expected diff in %s, was %v", c.desired, c.fields[i], d)
+			}
+		}
+		if RequiresRecreate(diffs) != c.recreate {
+			t.Errorf("desired %v: expected recreate %v, was %v", c.desired, c.recreate, !c.recreate)
+		}
+	}
+}
+
+func TestDiffInfoNoFamily(t *testing.T) {
+	portRange := PortRange{First: 0, Last: 1024}
+	actual := Info{Name: "bl", Type: TypeBitmapPort, PortRange: &portRange}
+	desired := Info{Name: "bl", Type: TypeBitmapPort, Family: FamilyInet, PortRange: &portRange}
+
+	if diffs := DiffInfo(desired, actual); len(diffs) != 0 {
+		t.Errorf("expected no diffs, was %v", diffs)
+	}
+	if info := withDefaults(desired); info.Family != "" {
+		t.Errorf("expected no family, was %s", info.Family)
+	}
+	if info := withDefaults(Info{Type: TypeBitmapIP, Family: FamilyInet6}); info.Family != FamilyInet6 {
+		t.Errorf("expected invalid family kept for Create to reject, was '%s'", info.Family)
+	}
+}
+
+func TestInfoDiffString(t *testing.T) {
+	d := InfoDiff{Field: "timeout", Desired: "600", Actual: "<nil>", Recreate: true}
+
+	if s := d.String(); s != "timeout: expected 600 but was <nil>" {
+		t.Errorf("expected 'timeout: expected 600 but was <nil>', was '%s'", s)
+	}
+}
@@ -0,0 +1,64 @@
+package ipset
+
+import "strings"
+
+// SetDryRun turns dry-run mode on or off. In dry-run mode commands that
+// change sets are recorded instead of run and report success. Commands
+// that only read, such as list, save and test, still run, so that Info,
+// Entries and Reconcile see the actual sets and plan against them. Reads
+// do not see the effect of recorded commands.
+func (set *IPSet) SetDryRun(enabled bool) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.dryRun = enabled
+}
+
+// Plan returns the commands recorded in dry-run mode, one per line, in the
+// format read by Restore and by ipset restore.
+func (set *IPSet) Plan() string {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if len(set.plan) == 0 {
+		return ""
+	}
+	return strings.Join(set.plan, "\n") + "\n"
+}
+
+// ResetPlan discards the recorded commands.
+func (set *IPSet) ResetPlan() {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.plan = nil
+}
+
+// readOnlyKinds are the operations that do not change any set. Their
+// commands are looked up in opKinds, so every name of them counts.
+var readOnlyKinds = map[OpKind]bool{
+	OpTest: true,
+	OpList: true,
+	OpSave: true,
+}
+
+// otherReadOnlyCommands are the read-only commands that are not
+// operations on sets.
+var otherReadOnlyCommands = map[string]bool{
+	"help":    true,
+	"-H":      true,
+	"version": true,
+	"-V":      true,
+}
+
+func isReadOnly(command string) bool {
+	return readOnlyKinds[opKinds[command]] || otherReadOnlyCommands[command]
+}
+
+func isReadOnlyCommand(fields []string) bool {
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "-") && !isReadOnly(fields[0]) {
+		// Skip global options such as -exist or -quiet.
+		fields = fields[1:]
+	}
+	return len(fields) > 0 && isReadOnly(fields[0])
+}
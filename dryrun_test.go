@@ -0,0 +1,114 @@
+package ipset
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDryRunPlan(t *testing.T) {
+	set := New()
+	defer set.Close()
+
+	set.SetDryRun(true)
+
+	if err := set.Create(noSuchSet, CreateOptionType("hash:net"), CreateOptionTimeout(60)); err != nil {
+		t.Fatalf("unexpected error on create: %v", err)
+	}
+	ok, err := set.AddElement(noSuchSet, mustParseCIDR(t, "10.0.0.0/8"), EntryOptionComment("feed x"))
+	if err != nil || !ok {
+		t.Fatalf("expected simulated success on add, was %v %v", ok, err)
+	}
+	if _, err := set.DelElement(noSuchSet, net.ParseIP("10.1.2.3")); err != nil {
+		t.Fatalf("unexpected error on del: %v", err)
+	}
+	if err := set.Restore(strings.NewReader("flush " + noSuchSet + "\nCOMMIT\n")); err != nil {
+		t.Fatalf("unexpected error on restore: %v", err)
+	}
+	if err := set.Swap(noSuchSet, namedSetV4); err != nil {
+		t.Fatalf("unexpected error on swap: %v", err)
+	}
+
+	expected := `create bl2 hash:net family inet timeout 60
+add bl2 10.0.0.0/8 comment "feed x"
+del bl2 10.1.2.3
+flush bl2
+swap bl2 bl4
+`
+	if plan := set.Plan(); plan != expected {
+		t.Errorf("expected plan\n%s\nwas\n%s", expected, plan)
+	}
+
+	set.ResetPlan()
+	if plan := set.Plan(); plan != "" {
+		t.Errorf("expected empty plan after reset, was %q", plan)
+	}
+}
+
+func TestDryRunDoesNotChangeSets(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	set.SetDryRun(true)
+	set.Create(noSuchSet)
+	set.Destroy(namedSetV4)
+
+	found, err := set.Test(namedSetV4, net.IPv4(1, 2, 3, 4))
+	if err != nil || !found {
+		t.Errorf("expected 1.2.3.4 still in set, found %v error %v", found, err)
+	}
+
+	set.SetDryRun(false)
+
+	_, err = set.Info(noSuchSet)
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("error should be ErrSetNotFound, was %v", err)
+	}
+}
+
+func TestDryRunShortForms(t *testing.T) {
+	set := New()
+	defer set.Close()
+
+	set.SetDryRun(true)
+
+	for _, cmd := range []string{"t bl 1.2.3.4", "l bl", "s"} {
+		set.Command(cmd)
+	}
+	if plan := set.Plan(); plan != "" {
+		t.Errorf("expected read-only commands left out of the plan, was %q", plan)
+	}
+
+	set.Command("a bl 1.2.3.4")
+	if plan := set.Plan(); plan != "a bl 1.2.3.4\n" {
+		t.Errorf("expected add in the plan, was %q", plan)
+	}
+}
+
+func TestIsReadOnlyCommand(t *testing.T) {
+	cases := map[string]bool{
+		"list bl":           true,
+		"save":              true,
+		"test bl 1.2.3.4":   true,
+		"-exist -T bl 1.2":  true,
+		"t bl 1.2.3.4":      true,
+		"l":                 true,
+		"s bl":              true,
+		"-quiet t bl 1":     true,
+		"a bl 1.2.3.4":      false,
+		"add bl 1.2.3.4":    false,
+		"-exist add bl 1":   false,
+		"create bl hash:ip": false,
+		"":                  false,
+	}
+
+	for cmd, expected := range cases {
+		if isReadOnlyCommand(strings.Fields(cmd)) != expected {
+			t.Errorf("'%s': expected read-only %v", cmd, expected)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+package ipset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Element is anything that can be rendered as the element part of an
+// add, del or test command. net.IP satisfies it.
+type Element interface {
+	String() string
+}
+
+// SetMember is the element of a list:set, i.e. the name of another set.
+// Before and After, if set, position the member relative to an existing
+// member of the list. At most one of them should be given.
+type SetMember struct {
+	Name   string
+	Before string
+	After  string
+}
+
+func (m SetMember) String() string {
+	switch {
+	case m.Before != "":
+		return fmt.Sprintf("%s before %s", m.Name, m.Before)
+	case m.After != "":
+		return fmt.Sprintf("%s after %s", m.Name, m.After)
+	}
+	return m.Name
+}
+
+func (m SetMember) args() []string {
+	switch {
+	case m.Before != "":
+		return []string{m.Name, "before", m.Before}
+	case m.After != "":
+		return []string{m.Name, "after", m.After}
+	}
+	return []string{m.Name}
+}
+
+func (m SetMember) Validate() error {
+	for _, name := range []string{m.Name, m.Before, m.After} {
+		if name == "" {
+			continue
+		}
+		if err := ValidateSetName(name); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidElement, err)
+		}
+	}
+	if m.Name == "" {
+		return fmt.Errorf("member name is required: %w", ErrInvalidElement)
+	}
+	if m.Before != "" && m.After != "" {
+		return fmt.Errorf("member %s both before and after: %w", m.Name, ErrInvalidElement)
+	}
+	return nil
+}
+
+// IPRange is an inclusive range of addresses, rendered as first-last.
+type IPRange struct {
+	First net.IP
+	Last  net.IP
+}
+
+func IPRangeFromNet(n *net.IPNet) IPRange {
+	first := n.IP.Mask(n.Mask)
+	last := make(net.IP, len(first))
+	for i := range first {
+		last[i] = first[i] | ^n.Mask[i]
+	}
+	return IPRange{First: first, Last: last}
+}
+
+func (r IPRange) String() string {
+	return fmt.Sprintf("%s-%s", r.First.String(), r.Last.String())
+}
+
+func (r IPRange) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *IPRange) UnmarshalText(text []byte) error {
+	parsed, err := ParseIPRange(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r IPRange) Contains(ip net.IP) bool {
+	return compareIP(r.First, ip) <= 0 && compareIP(ip, r.Last) <= 0
+}
+
+func ParseIPRange(s string) (IPRange, error) {
+	first, last, found := strings.Cut(s, "-")
+	if !found {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return IPRange{}, fmt.Errorf("invalid range '%s'", s)
+		}
+		return IPRangeFromNet(n), nil
+	}
+
+	r := IPRange{First: net.ParseIP(first), Last: net.ParseIP(last)}
+	if r.First == nil || r.Last == nil {
+		return IPRange{}, fmt.Errorf("invalid range '%s'", s)
+	}
+	if err := r.Validate(); err != nil {
+		return IPRange{}, fmt.Errorf("invalid range '%s': %w", s, err)
+	}
+	return r, nil
+}
+
+// compareIP orders addresses numerically. IPv4 addresses sort before IPv6
+// ones.
+func compareIP(a, b net.IP) int {
+	if a4, b4 := a.To4(), b.To4(); a4 != nil && b4 != nil {
+		return bytes.Compare(a4, b4)
+	} else if a4 != nil {
+		return -1
+	} else if b4 != nil {
+		return 1
+	}
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// PortRange is an inclusive range of ports, rendered as first-last.
+type PortRange struct {
+	First int
+	Last  int
+}
+
+func (r PortRange) String() string {
+	return fmt.Sprintf("%d-%d", r.First, r.Last)
+}
+
+func (r PortRange) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *PortRange) UnmarshalText(text []byte) error {
+	parsed, err := ParsePortRange(string(text))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r PortRange) Contains(port int) bool {
+	return r.First <= port && port <= r.Last
+}
+
+// Validate checks that both ends are ports and that the range does not end
+// before it starts.
+func (r PortRange) Validate() error {
+	if err := validatePortNumber(r.First); err != nil {
+		return err
+	}
+	if err := validatePortNumber(r.Last); err != nil {
+		return err
+	}
+	if r.First > r.Last {
+		return fmt.Errorf("port range %s ends before it starts: %w", r, ErrInvalidElement)
+	}
+	return nil
+}
+
+func ParsePortRange(s string) (PortRange, error) {
+	first, last, found := strings.Cut(s, "-")
+	f, err := strconv.Atoi(first)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range '%s'", s)
+	}
+	l := f
+	if found {
+		if l, err = strconv.Atoi(last); err != nil {
+			return PortRange{}, fmt.Errorf("invalid port range '%s'", s)
+		}
+	}
+
+	r := PortRange{First: f, Last: l}
+	if err := r.Validate(); err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range '%s': %w", s, err)
+	}
+	return r, nil
+}
+
+// IPMAC is the element of a bitmap:ip,mac set. MAC may be left out, in
+// which case the kernel fills it in from the first matching packet.
+type IPMAC struct {
+	IP  net.IP
+	MAC net.HardwareAddr
+}
+
+func (e IPMAC) String() string {
+	if len(e.MAC) == 0 {
+		return e.IP.String()
+	}
+	return fmt.Sprintf("%s,%s", e.IP.String(), e.MAC.String())
+}
+
+// Port is the element of a bitmap:port set, and a bare port, which libipset
+// takes as tcp, in ip,port style elements.
+type Port int
+
+func (p Port) String() string {
+	return strconv.Itoa(int(p))
+}
+
+// NetNet is the element of a hash:net,net set. The two prefixes must be
+// of the same family but may have different lengths.
+type NetNet struct {
+	First  *net.IPNet
+	Second *net.IPNet
+}
+
+func (e NetNet) String() string {
+	return fmt.Sprintf("%s,%s", e.First, e.Second)
+}
+
+func (e NetNet) Validate() error {
+	return validateNets(e.First, e.Second)
+}
+
+// NetPortNet is the element of a hash:net,port,net set.
+type NetPortNet struct {
+	First  *net.IPNet
+	Port   PortComponent
+	Second *net.IPNet
+}
+
+func (e NetPortNet) String() string {
+	return fmt.Sprintf("%s,%s,%s", e.First, e.Port, e.Second)
+}
+
+func (e NetPortNet) Validate() error {
+	if err := validateNets(e.First, e.Second); err != nil {
+		return err
+	}
+	if e.Port == nil {
+		return fmt.Errorf("port is required: %w", ErrInvalidElement)
+	}
+	return e.Port.validatePort(e.First.IP.To4() == nil)
+}
+
+func validateNets(first, second *net.IPNet) error {
+	if first == nil || second == nil {
+		return fmt.Errorf("both networks are required: %w", ErrInvalidElement)
+	}
+	if (first.IP.To4() == nil) != (second.IP.To4() == nil) {
+		return fmt.Errorf("%s and %s differ in family: %w", first, second, ErrInvalidElement)
+	}
+	return nil
+}
+
+// Entry is an element together with its per-element options, either as
+// added or tested, or as listed by the kernel. Timeout is the remaining
+// time in seconds when listed.
+type Entry struct {
+	Element Element
+	Timeout *int
+	Packets *uint64
+	Bytes   *uint64
+	Comment *string
+	NoMatch bool
+}
+
+type EntryOption func(e Entry) Entry
+
+func EntryOptionTimeout(timeout int) EntryOption {
+	return func(e Entry) Entry {
+		e.Timeout = &timeout
+		return e
+	}
+}
+
+func EntryOptionComment(comment string) EntryOption {
+	return func(e Entry) Entry {
+		e.Comment = &comment
+		return e
+	}
+}
+
+func EntryOptionNoMatch() EntryOption {
+	return func(e Entry) Entry {
+		e.NoMatch = true
+		return e
+	}
+}
+
+func newEntry(elem Element, options []EntryOption) Entry {
+	entry := Entry{Element: elem}
+	for _, o := range options {
+		entry = o(entry)
+	}
+	return entry
+}
+
+// entryJSON is Entry with the element as a plain string, so that entries
+// decode without knowing the set type.
+type entryJSON struct {
+	Element string  `json:"element"`
+	Timeout *int    `json:"timeout,omitempty"`
+	Packets *uint64 `json:"packets,omitempty"`
+	Bytes   *uint64 `json:"bytes,omitempty"`
+	Comment *string `json:"comment,omitempty"`
+	NoMatch bool    `json:"nomatch,omitempty"`
+}
+
+func (e Entry) MarshalJSON() ([]byte, error) {
+	j := entryJSON{
+		Timeout: e.Timeout,
+		Packets: e.Packets,
+		Bytes:   e.Bytes,
+		Comment: e.Comment,
+		NoMatch: e.NoMatch,
+	}
+	if e.Element != nil {
+		j.Element = e.Element.String()
+	}
+	return json.Marshal(j)
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var j entryJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	*e = Entry{
+		Element: rawElement(j.Element),
+		Timeout: j.Timeout,
+		Packets: j.Packets,
+		Bytes:   j.Bytes,
+		Comment: j.Comment,
+		NoMatch: j.NoMatch,
+	}
+	return nil
+}
+
+func (e Entry) String() string {
+	s := e.Element.String()
+	if e.Timeout != nil {
+		s = s + fmt.Sprintf(" timeout %d", *e.Timeout)
+	}
+	if e.Packets != nil {
+		s = s + fmt.Sprintf(" packets %d", *e.Packets)
+	}
+	if e.Bytes != nil {
+		s = s + fmt.Sprintf(" bytes %d", *e.Bytes)
+	}
+	if e.Comment != nil {
+		s = s + fmt.Sprintf(" comment \"%s\"", *e.Comment)
+	}
+	if e.NoMatch {
+		s = s + " nomatch"
+	}
+	return s
+}
+
+// parseEntry parses an add line of save output, e.g.
+//
+//	add bl 1.2.3.4 timeout 599 packets 0 bytes 0 comment "x"
+func parseEntry(line string) (set string, entry Entry, ok bool) {
+	fields := splitQuoted(line)
+	if len(fields) < 3 || fields[0] != "add" {
+		return "", Entry{}, false
+	}
+
+	entry.Element = rawElement(fields[2])
+
+	for i := 3; i < len(fields); i++ {
+		val := ""
+		if i+1 < len(fields) {
+			val = fields[i+1]
+		}
+
+		switch fields[i] {
+		case "timeout":
+			entry.Timeout = parseIntPtr(val)
+			i++
+		case "packets":
+			entry.Packets = parseUint64Ptr(val)
+			i++
+		case "bytes":
+			entry.Bytes = parseUint64Ptr(val)
+			i++
+		case "comment":
+			entry.Comment = &val
+			i++
+		case "nomatch":
+			entry.NoMatch = true
+		}
+	}
+
+	return fields[1], entry, true
+}
+
+// splitQuoted is strings.Fields that keeps double-quoted strings, such as
+// comments, as single fields with the quotes removed.
+func splitQuoted(s string) []string {
+	var fields []string
+	var field strings.Builder
+	inField, quoted := false, false
+
+	for i := 0; i < len(s); i++ {
+		switch r := s[i]; {
+		case r == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (r == ' ' || r == '\t' || r == '\n' || r == '\r'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(r)
+			inField = true
+		}
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields
+}
+
+func parseUint64Ptr(s string) *uint64 {
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// ValidateElement checks that elem, with the options of an add or test,
+// can be passed to libipset as is. It is what AddElement and TestElement
+// check before running a command, for callers taking elements from
+// untrusted input.
+func ValidateElement(elem Element, options ...EntryOption) error {
+	if err := validateElement(elem); err != nil {
+		return err
+	}
+	return newEntry(elem, options).validate()
+}
+
+// maxCommentLen is IPSET_MAX_COMMENT_SIZE.
+const maxCommentLen = 255
+
+// validateElement runs the element's own validation, if any, and checks
+// that it renders as words libipset can take as arguments. libipset picks
+// global options such as -exist and -file out of anywhere in the
+// arguments, so no component may start with '-'.
+func validateElement(elem Element) error {
+	if elem == nil {
+		return fmt.Errorf("no element: %w", ErrInvalidElement)
+	}
+	if v, ok := elem.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, arg := range elementArgs(elem) {
+		if arg == "" || strings.IndexFunc(arg, isSpaceOrControl) >= 0 || looksLikeOption(arg) {
+			return fmt.Errorf("%q: %w", elem.String(), ErrInvalidElement)
+		}
+	}
+	return nil
+}
+
+func isSpaceOrControl(c rune) bool {
+	return unicode.IsSpace(c) || unicode.IsControl(c)
+}
+
+// looksLikeOption reports whether arg, or any of its comma separated
+// components, starts with '-'.
+func looksLikeOption(arg string) bool {
+	for _, c := range strings.Split(arg, ",") {
+		if strings.HasPrefix(c, "-") {
+			return true
+		}
+	}
+	return false
+}
+
+// elementArgs returns the element as command arguments. Most elements are
+// a single argument.
+func elementArgs(elem Element) []string {
+	if a, ok := elem.(interface{ args() []string }); ok {
+		return a.args()
+	}
+	return []string{elem.String()}
+}
+
+// validate checks the options of an entry about to be added or tested.
+func (e Entry) validate() error {
+	if e.Timeout != nil && *e.Timeout < 0 {
+		return fmt.Errorf("negative timeout %d: %w", *e.Timeout, ErrInvalidElement)
+	}
+	if e.Comment != nil {
+		if len(*e.Comment) > maxCommentLen {
+			return fmt.Errorf("comment longer than %d characters: %w", maxCommentLen, ErrInvalidElement)
+		}
+		if strings.ContainsRune(*e.Comment, '"') || strings.IndexFunc(*e.Comment, unicode.IsControl) >= 0 {
+			return fmt.Errorf("comment %q contains quotes or control characters: %w", *e.Comment, ErrInvalidElement)
+		}
+		if !utf8.ValidString(*e.Comment) {
+			return fmt.Errorf("comment %q is not valid UTF-8: %w", *e.Comment, ErrInvalidElement)
+		}
+		if strings.HasPrefix(*e.Comment, "-") {
+			return fmt.Errorf("comment %q starts with '-': %w", *e.Comment, ErrInvalidElement)
+		}
+	}
+	return nil
+}
+
+// args returns the entry as the arguments of an add or test command.
+func (e Entry) args() []string {
+	args := elementArgs(e.Element)
+	if e.Timeout != nil {
+		args = append(args, "timeout", strconv.Itoa(*e.Timeout))
+	}
+	if e.Packets != nil {
+		args = append(args, "packets", strconv.FormatUint(*e.Packets, 10))
+	}
+	if e.Bytes != nil {
+		args = append(args, "bytes", strconv.FormatUint(*e.Bytes, 10))
+	}
+	if e.Comment != nil {
+		args = append(args, "comment", *e.Comment)
+	}
+	if e.NoMatch {
+		args = append(args, "nomatch")
+	}
+	return args
+}
+
+// rawElement is an element as listed by the kernel.
+type rawElement string
+
+func (e rawElement) String() string {
+	return string(e)
+}
@@ -0,0 +1,173 @@
+// Package feed reads IP blocklists in the common text formats, such as
+// FireHOL netsets, Spamhaus DROP and plain one-address-per-line lists, and
+// loads them into sets.
+package feed
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/tancred/ipset"
+)
+
+// LineError describes a line that could not be parsed.
+type LineError struct {
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v: '%s'", e.Line, e.Err, e.Text)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Result holds the elements read from a feed, in order of appearance and
+// without duplicates, and the lines that were skipped as malformed.
+type Result struct {
+	Elements []ipset.Element
+	Errors   []*LineError
+}
+
+// Inet returns the IPv4 elements.
+func (r Result) Inet() []ipset.Element {
+	return r.filter(false)
+}
+
+// Inet6 returns the IPv6 elements.
+func (r Result) Inet6() []ipset.Element {
+	return r.filter(true)
+}
+
+func (r Result) filter(v6 bool) []ipset.Element {
+	var elems []ipset.Element
+	for _, e := range r.Elements {
+		if isIPv6(e) == v6 {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+func isIPv6(elem ipset.Element) bool {
+	switch e := elem.(type) {
+	case net.IP:
+		return e.To4() == nil
+	case *net.IPNet:
+		return e.IP.To4() == nil
+	case ipset.IPRange:
+		return e.First.To4() == nil
+	}
+	return false
+}
+
+// Parse reads a feed. Everything after a '#' or ';' is a comment, and only
+// the first field of a line is used, so trailing annotations such as the
+// SBL references of Spamhaus DROP are ignored. An entry is an address, a
+// CIDR prefix or a first-last address range. Addresses are normalised and
+// prefixes masked to their network.
+//
+// Malformed lines are collected in Result.Errors rather than failing the
+// parse. The returned error is only set if reading fails.
+func Parse(r io.Reader) (Result, error) {
+	var res Result
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+
+	for scanner.Scan() {
+		lineno++
+		line := scanner.Text()
+
+		text := line
+		if i := strings.IndexAny(text, "#;"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		elem, err := parseEntry(fields[0])
+		if err != nil {
+			res.Errors = append(res.Errors, &LineError{Line: lineno, Text: strings.TrimSpace(line), Err: err})
+			continue
+		}
+
+		if k := elem.String(); !seen[k] {
+			seen[k] = true
+			res.Elements = append(res.Elements, elem)
+		}
+	}
+
+	return res, scanner.Err()
+}
+
+func parseEntry(s string) (ipset.Element, error) {
+	if first, last, found := strings.Cut(s, "-"); found {
+		// The library decides what a valid range is; only its reason is
+		// kept, as the line is quoted anyway.
+		if _, err := ipset.ParseIPRange(s); err != nil {
+			if reason := errors.Unwrap(err); reason != nil {
+				return nil, fmt.Errorf("invalid range: %w", reason)
+			}
+			return nil, fmt.Errorf("invalid range")
+		}
+		return ipset.IPRange{First: parseIP(first), Last: parseIP(last)}, nil
+	}
+
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix")
+		}
+		return n, nil
+	}
+
+	ip := parseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address")
+	}
+	return ip, nil
+}
+
+// parseIP parses an address, returning IPv4 addresses in their 4-byte
+// form so that they print and compare as IPv4.
+func parseIP(s string) net.IP {
+	ip := net.ParseIP(s)
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(s, ":") {
+		return ip4
+	}
+	return ip
+}
+
+// Adder is the part of *ipset.IPSet used by Load.
+type Adder interface {
+	AddElements(name string, elems []ipset.Element, options ...ipset.EntryOption) (int, error)
+}
+
+// Load parses the feed read from r and adds the elements of the set's
+// family to the named set. The result of the parse is returned along with
+// any error from reading or adding.
+func Load(set Adder, name string, family ipset.Family, r io.Reader, options ...ipset.EntryOption) (Result, error) {
+	res, err := Parse(r)
+	if err != nil {
+		return res, err
+	}
+
+	elems := res.Inet()
+	if family == ipset.FamilyInet6 {
+		elems = res.Inet6()
+	}
+
+	_, err = set.AddElements(name, elems, options...)
+	return res, err
+}
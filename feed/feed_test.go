@@ -0,0 +1,167 @@
+package feed
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tancred/ipset"
+)
+
+const fireholNetset = `#
+# firehol_level1
+#
+# Maintainer      : FireHOL
+#
+0.0.0.0/8
+1.10.16.0/20
+1.19.0.0/16
+223.254.0.0/16
+`
+
+const spamhausDrop = `; Spamhaus DROP List 2024/01/01 - (c) 2024 The Spamhaus Project
+; Last-Modified: Mon, 01 Jan 2024 00:00:00 GMT
+1.10.16.0/20 ; SBL256894
+1.19.0.0/16 ; SBL434604
+2a06:e480::/29 ; SBL301771
+`
+
+const plainList = `192.0.2.1
+192.0.2.2   # seen 2024-01-01
+192.0.2.1
+2001:db8::0001
+198.51.100.10-198.51.100.20
+`
+
+func elementStrings(elems []ipset.Element) string {
+	s := make([]string, len(elems))
+	for i, e := range elems {
+		s[i] = e.String()
+	}
+	return strings.Join(s, " ")
+}
+
+func TestParseFormats(t *testing.T) {
+	cases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"firehol", fireholNetset, "0.0.0.0/8 1.10.16.0/20 1.19.0.0/16 223.254.0.0/16"},
+		{"spamhaus", spamhausDrop, "1.10.16.0/20 1.19.0.0/16 2a06:e480::/29"},
+		{"plain", plainList, "192.0.2.1 192.0.2.2 2001:db8::1 198.51.100.10-198.51.100.20"},
+	}
+
+	for _, c := range cases {
+		res, err := Parse(strings.NewReader(c.input))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+			continue
+		}
+		if len(res.Errors) != 0 {
+			t.Errorf("%s: unexpected malformed lines %v", c.name, res.Errors)
+		}
+		if s := elementStrings(res.Elements); s != c.expected {
+			t.Errorf("%s: expected '%s', was '%s'", c.name, c.expected, s)
+		}
+	}
+}
+
+func TestParseNormalises(t *testing.T) {
+	res, _ := Parse(strings.NewReader("10.1.2.3/8\n10.0.0.0/8\n2001:0db8:0000:0000::/32\n"))
+
+	if s := elementStrings(res.Elements); s != "10.0.0.0/8 2001:db8::/32" {
+		t.Errorf("expected '10.0.0.0/8 2001:db8::/32', was '%s'", s)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	input := `1.2.3.4
+not-an-address
+1.2.3.0/33
+# fine
+1.2.3.9-1.2.3.1
+1.2.3.4-::1
+300.1.1.1
+5.6.7.8
+`
+	res, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if s := elementStrings(res.Elements); s != "1.2.3.4 5.6.7.8" {
+		t.Errorf("expected '1.2.3.4 5.6.7.8', was '%s'", s)
+	}
+
+	var lines []int
+	for _, e := range res.Errors {
+		lines = append(lines, e.Line)
+	}
+	expected := []int{2, 3, 5, 6, 7}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected malformed lines %v, was %v", expected, lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("expected malformed lines %v, was %v", expected, lines)
+			break
+		}
+	}
+
+	if s := res.Errors[0].Error(); s != "line 2: invalid range: 'not-an-address'" {
+		t.Errorf("unexpected error message '%s'", s)
+	}
+	for _, e := range res.Errors[2:4] {
+		if !errors.Is(e, ipset.ErrInvalidElement) {
+			t.Errorf("line %d: error should be ErrInvalidElement, was %v", e.Line, e.Err)
+		}
+	}
+}
+
+func TestResultFamilies(t *testing.T) {
+	res, _ := Parse(strings.NewReader(spamhausDrop + plainList))
+
+	if s := elementStrings(res.Inet()); s != "1.10.16.0/20 1.19.0.0/16 192.0.2.1 192.0.2.2 198.51.100.10-198.51.100.20" {
+		t.Errorf("unexpected IPv4 elements '%s'", s)
+	}
+	if s := elementStrings(res.Inet6()); s != "2a06:e480::/29 2001:db8::1" {
+		t.Errorf("unexpected IPv6 elements '%s'", s)
+	}
+}
+
+type fakeAdder struct {
+	name  string
+	elems []ipset.Element
+	err   error
+}
+
+func (a *fakeAdder) AddElements(name string, elems []ipset.Element, options ...ipset.EntryOption) (int, error) {
+	a.name = name
+	a.elems = append(a.elems, elems...)
+	return len(elems), a.err
+}
+
+func TestLoad(t *testing.T) {
+	adder := &fakeAdder{}
+
+	res, err := Load(adder, "drop6", ipset.FamilyInet6, strings.NewReader(spamhausDrop))
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(res.Elements) != 3 {
+		t.Errorf("expected 3 parsed elements, was %d", len(res.Elements))
+	}
+	if adder.name != "drop6" {
+		t.Errorf("expected set 'drop6', was '%s'", adder.name)
+	}
+	if s := elementStrings(adder.elems); s != "2a06:e480::/29" {
+		t.Errorf("expected '2a06:e480::/29' loaded, was '%s'", s)
+	}
+
+	adder = &fakeAdder{err: ipset.ErrSetNotFound}
+	_, err = Load(adder, "nope", ipset.FamilyInet, strings.NewReader(spamhausDrop))
+	if !errors.Is(err, ipset.ErrSetNotFound) {
+		t.Errorf("error should be ErrSetNotFound, was %v", err)
+	}
+}
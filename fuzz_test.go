@@ -0,0 +1,153 @@
+package ipset
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzParseCreateLine(f *testing.F) {
+	f.Add("create bl hash:ip family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0xd263dc02")
+	f.Add("create bl4 bitmap:port range 1024-65535 timeout 60 counters comment")
+	f.Add("create bll list:set size 8 skbinfo")
+	f.Add("create")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		info, err := ParseCreateLine(line)
+		if err != nil {
+			return
+		}
+		if info.Name == "" || info.Type == "" {
+			t.Errorf("parsed %q without name or type: %+v", line, info)
+		}
+
+		again, err := ParseCreateLine(info.CreateLine())
+		if err != nil {
+			t.Fatalf("%q: can't parse own rendering '%s': %v", line, info.CreateLine(), err)
+		}
+		if again.CreateLine() != info.CreateLine() {
+			t.Errorf("%q: '%s' parsed back as '%s'", line, info.CreateLine(), again.CreateLine())
+		}
+	})
+}
+
+func FuzzParseEntry(f *testing.F) {
+	f.Add(`add bl 1.2.3.4 timeout 60 packets 1 bytes 84 comment "a b" nomatch`)
+	f.Add(`add bl 10.0.0.0/8,tcp:80 comment "`)
+	f.Add(`add bll bl4`)
+
+	f.Fuzz(func(t *testing.T, line string) {
+		set, entry, ok := parseEntry(line)
+		if !ok {
+			return
+		}
+		if set == "" && !strings.Contains(line, `""`) {
+			t.Errorf("parsed %q without set name", line)
+		}
+		_ = entry.String()
+	})
+}
+
+func FuzzParseListHeaders(f *testing.F) {
+	f.Add("Name: bl\nType: hash:ip\nRevision: 6\nHeader: family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x3d8ad3e1\nSize in memory: 200\nReferences: 0\nNumber of entries: 0\n")
+	f.Add("Type: hash:ip\nName:\nHeader: range")
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		for _, s := range parseListHeaders(msg) {
+			_ = s.Utilisation()
+		}
+	})
+}
+
+func FuzzParseIPRange(f *testing.F) {
+	f.Add("10.0.0.0/8")
+	f.Add("1.2.3.4-1.2.3.50")
+	f.Add("2001:db8::-2001:db8::ffff")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		r, err := ParseIPRange(s)
+		if err != nil {
+			return
+		}
+		if (r.First.To4() == nil) != (r.Last.To4() == nil) || compareIP(r.First, r.Last) > 0 {
+			t.Fatalf("%q: accepted mixed or reversed range '%s'", s, r)
+		}
+
+		again, err := ParseIPRange(r.String())
+		if err != nil {
+			t.Fatalf("%q: can't parse own rendering '%s': %v", s, r, err)
+		}
+		if !again.First.Equal(r.First) || !again.Last.Equal(r.Last) {
+			t.Errorf("%q: '%s' parsed back as '%s'", s, r, again)
+		}
+		_ = r.CIDRs()
+	})
+}
+
+func FuzzParsePortRange(f *testing.F) {
+	f.Add("1024-65535")
+	f.Add("80")
+
+	f.Fuzz(func(t *testing.T, s string) {
+		r, err := ParsePortRange(s)
+		if err != nil {
+			return
+		}
+		if r.First < 0 || r.Last > 65535 || r.First > r.Last {
+			t.Fatalf("%q: accepted invalid port range '%s'", s, r)
+		}
+
+		again, err := ParsePortRange(r.String())
+		if err != nil || again != r {
+			t.Errorf("%q: '%s' parsed back as '%s', error %v", s, r, again, err)
+		}
+	})
+}
+
+// FuzzEntryString renders entries and checks that they read back from a
+// save line.
+func FuzzEntryString(f *testing.F) {
+	f.Add([]byte{10, 0, 0, 0}, 8, 60, "feed x", true)
+	f.Add(make([]byte, 16), 0, -1, "", false)
+
+	f.Fuzz(func(t *testing.T, ip []byte, ones int, timeout int, comment string, nomatch bool) {
+		if len(ip) != net.IPv4len && len(ip) != net.IPv6len {
+			return
+		}
+		elem := &net.IPNet{IP: ip, Mask: net.CIDRMask(ones, len(ip)*8)}
+		if elem.Mask == nil {
+			return
+		}
+
+		options := []EntryOption{EntryOptionTimeout(timeout), EntryOptionComment(comment)}
+		if nomatch {
+			options = append(options, EntryOptionNoMatch())
+		}
+		entry := newEntry(elem, options)
+
+		if validateElement(elem) != nil || entry.validate() != nil {
+			return
+		}
+		if !utf8.ValidString(comment) {
+			t.Fatalf("comment %q accepted but not valid UTF-8", comment)
+		}
+
+		set, parsed, ok := parseEntry("add bl " + entry.String())
+		if !ok || set != "bl" {
+			t.Fatalf("can't parse '%s'", entry)
+		}
+		if parsed.Element.String() != elem.String() {
+			t.Errorf("element '%s' parsed back as '%s'", elem, parsed.Element)
+		}
+		if parsed.Timeout == nil || *parsed.Timeout != timeout {
+			t.Errorf("timeout %d parsed back as %v", timeout, parsed.Timeout)
+		}
+		if parsed.Comment == nil || *parsed.Comment != comment {
+			t.Errorf("comment %q parsed back as %v", comment, parsed.Comment)
+		}
+		if parsed.NoMatch != nomatch {
+			t.Errorf("nomatch %v parsed back as %v", nomatch, parsed.NoMatch)
+		}
+	})
+}
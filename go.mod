@@ -2,4 +2,7 @@ module github.com/tancred/ipset
 
 go 1.24
 
-require github.com/mattn/go-pointer v0.0.1
+require (
+	github.com/mattn/go-pointer v0.0.1
+	golang.org/x/sys v0.30.0
+)
@@ -0,0 +1,190 @@
+package ipset
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+var maliciousNames = []string{
+	"",
+	"bl flush",
+	"bl\nflush",
+	"bl\tdestroy",
+	"bl;destroy",
+	"bl\"x",
+	"-exist",
+	"bl\x00x",
+	"blä",
+	strings.Repeat("x", 32),
+}
+
+// refuseAll fails the test if any command reaches libipset.
+func refuseAll(t *testing.T) Interceptor {
+	return func(op Operation, next func() error) error {
+		t.Errorf("command reached libipset: %q", op.Command)
+		return errors.New("refused")
+	}
+}
+
+func TestValidateSetName(t *testing.T) {
+	for _, name := range maliciousNames {
+		if err := ValidateSetName(name); !errors.Is(err, ErrInvalidSetName) {
+			t.Errorf("%q: error should be ErrInvalidSetName, was %v", name, err)
+		}
+	}
+
+	for _, name := range []string{"bl", "bl-tmp", "feed_1.v6", "a:b+c=d@e", strings.Repeat("x", 31)} {
+		if err := ValidateSetName(name); err != nil {
+			t.Errorf("%q: unexpected error %v", name, err)
+		}
+	}
+}
+
+func TestMaliciousNamesRejected(t *testing.T) {
+	set := New(OptionInterceptor(refuseAll(t)))
+	defer set.Close()
+
+	ip := net.ParseIP("1.2.3.4")
+
+	for _, name := range maliciousNames {
+		errs := []error{
+			set.Create(name),
+			set.Destroy(name),
+			set.Flush(name),
+			set.Swap(name, namedSetV4),
+			set.Swap(namedSetV4, name),
+			set.Rename(namedSetV4, name),
+		}
+		_, err := set.Info(name)
+		errs = append(errs, err)
+		_, err = set.Add(name, ip)
+		errs = append(errs, err)
+		_, err = set.Add6(name, ip)
+		errs = append(errs, err)
+		_, err = set.Test(name, ip)
+		errs = append(errs, err)
+		_, err = set.DelElement(name, ip)
+		errs = append(errs, err)
+		_, err = set.Entries(name)
+		errs = append(errs, err)
+		_, err = set.Stats(name)
+		errs = append(errs, err)
+		if name != "" {
+			_, err = set.Save(name)
+			errs = append(errs, err)
+		}
+
+		for i, err := range errs {
+			if !errors.Is(err, ErrInvalidSetName) {
+				t.Errorf("%q, call %d: error should be ErrInvalidSetName, was %v", name, i, err)
+			}
+		}
+	}
+}
+
+func TestMaliciousElementsRejected(t *testing.T) {
+	set := New(OptionInterceptor(refuseAll(t)))
+	defer set.Close()
+
+	elems := []Element{
+		nil,
+		rawElement(""),
+		rawElement("1.2.3.4 timeout 1"),
+		rawElement("1.2.3.4\nflush bl4"),
+		rawElement("1.2.3.4\x00"),
+		SetMember{Name: "bl4 before bl6"},
+		SetMember{Name: "bl4", Before: "bl6\ndestroy"},
+		rawElement("-exist"),
+		rawElement("-file"),
+		rawElement("1.2.3.4,-quiet"),
+		Port(-1),
+	}
+
+	for _, elem := range elems {
+		if _, err := set.AddElement(namedSetV4, elem); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%v: error should be ErrInvalidElement on add, was %v", elem, err)
+		}
+		if _, err := set.DelElement(namedSetV4, elem); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%v: error should be ErrInvalidElement on del, was %v", elem, err)
+		}
+		if _, err := set.TestElement(namedSetV4, elem); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%v: error should be ErrInvalidElement on test, was %v", elem, err)
+		}
+		if err := ValidateElement(elem); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%v: error should be ErrInvalidElement on validate, was %v", elem, err)
+		}
+	}
+
+	comments := []string{"a\"b", "a\nflush bl4", strings.Repeat("x", 256), "-file", "-exist", "-output", "\xb0", "ok\xff"}
+	for _, c := range comments {
+		_, err := set.AddElement(namedSetV4, net.ParseIP("1.2.3.4"), EntryOptionComment(c))
+		if !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("comment %q: error should be ErrInvalidElement, was %v", c, err)
+		}
+		err = ValidateElement(net.ParseIP("1.2.3.4"), EntryOptionComment(c))
+		if !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("comment %q: error should be ErrInvalidElement on validate, was %v", c, err)
+		}
+	}
+}
+
+// TestArgumentsStaySeparate checks that arguments are passed as given, so
+// a comment with spaces stays a single argument.
+func TestArgumentsStaySeparate(t *testing.T) {
+	var ops []Operation
+	set := New(OptionInterceptor(func(op Operation, next func() error) error {
+		ops = append(ops, op)
+		return next()
+	}))
+	defer set.Close()
+
+	set.SetDryRun(true)
+
+	set.AddElement(namedSetV4, net.ParseIP("1.2.3.4"), EntryOptionComment("flush bl6"))
+	set.AddElement(listSet, SetMember{Name: namedSetV4, After: namedSetV6})
+
+	expected := "add bl4 1.2.3.4 comment \"flush bl6\"\nadd bll bl4 after bl6\n"
+	if plan := set.Plan(); plan != expected {
+		t.Errorf("expected plan %q, was %q", expected, plan)
+	}
+
+	if len(ops) != 2 || ops[1].Set != listSet || ops[1].Element != namedSetV4 {
+		t.Errorf("unexpected operations %+v", ops)
+	}
+}
+
+// TestArgumentsStaySeparateKernel checks on the real argv path that a
+// comment with spaces and option names is stored as given.
+func TestArgumentsStaySeparateKernel(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	if err := set.Create(noSuchSet, CreateOptionComment()); err != nil {
+		t.Fatalf("unexpected error creating set: %v", err)
+	}
+
+	comment := "flush bl4 nomatch -exist"
+	if _, err := set.AddElement(noSuchSet, net.ParseIP("1.2.3.4"), EntryOptionComment(comment)); err != nil {
+		t.Fatalf("unexpected error adding element: %v", err)
+	}
+
+	entries, err := set.Entries(noSuchSet)
+	if err != nil {
+		t.Fatalf("unexpected error listing entries: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Comment == nil || *entries[0].Comment != comment {
+		t.Fatalf("expected one entry with comment %q, was %v", comment, entries)
+	}
+	if entries[0].NoMatch {
+		t.Errorf("comment should not have set nomatch")
+	}
+
+	if ok, _ := set.Test(namedSetV4, net.IPv4(1, 2, 3, 4)); !ok {
+		t.Errorf("comment should not have flushed %s", namedSetV4)
+	}
+}
@@ -0,0 +1,111 @@
+package ipset
+
+import "strings"
+
+// OpKind is the kind of operation a command performs, named after the
+// long form of the ipset command.
+type OpKind string
+
+const (
+	OpCreate  OpKind = "create"
+	OpDestroy OpKind = "destroy"
+	OpAdd     OpKind = "add"
+	OpDel     OpKind = "del"
+	OpTest    OpKind = "test"
+	OpList    OpKind = "list"
+	OpSave    OpKind = "save"
+	OpFlush   OpKind = "flush"
+	OpRename  OpKind = "rename"
+	OpSwap    OpKind = "swap"
+	OpOther   OpKind = "other"
+)
+
+var opKinds = map[string]OpKind{
+	"create": OpCreate, "n": OpCreate, "-N": OpCreate,
+	"destroy": OpDestroy, "x": OpDestroy, "-X": OpDestroy,
+	"add": OpAdd, "a": OpAdd, "-A": OpAdd,
+	"del": OpDel, "d": OpDel, "-D": OpDel,
+	"test": OpTest, "t": OpTest, "-T": OpTest,
+	"list": OpList, "l": OpList, "-L": OpList,
+	"save": OpSave, "s": OpSave, "-S": OpSave,
+	"flush": OpFlush, "f": OpFlush, "-F": OpFlush,
+	"rename": OpRename, "e": OpRename, "-E": OpRename,
+	"swap": OpSwap, "w": OpSwap, "-W": OpSwap,
+}
+
+// Operation describes a command about to run. Set is empty for commands
+// on all sets, and Element is only set for add, del and test. Command is
+// the full command line given to libipset.
+type Operation struct {
+	Kind    OpKind
+	Set     string
+	Element string
+	Command string
+}
+
+// newOperation describes the command whose words are fields.
+func newOperation(fields []string, command string) Operation {
+	op := Operation{Kind: OpOther, Command: command}
+
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "-") && opKinds[fields[0]] == "" {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return op
+	}
+
+	if kind, ok := opKinds[fields[0]]; ok {
+		op.Kind = kind
+	}
+	if len(fields) > 1 {
+		op.Set = fields[1]
+	}
+	if len(fields) > 2 && (op.Kind == OpAdd || op.Kind == OpDel || op.Kind == OpTest) {
+		op.Element = fields[2]
+	}
+
+	return op
+}
+
+// Interceptor is called around every command, including those run by the
+// higher level methods. It must call next to run the command, or the rest
+// of the chain, and returns the error the caller sees. Returning an error
+// without calling next prevents the command from running.
+//
+// Interceptors run without the set locked and may be called concurrently.
+type Interceptor func(op Operation, next func() error) error
+
+type config struct {
+	interceptors []Interceptor
+}
+
+type Option func(c config) config
+
+// OptionInterceptor adds an interceptor. Interceptors are called in the
+// order added, the first one outermost.
+func OptionInterceptor(i Interceptor) Option {
+	return func(c config) config {
+		c.interceptors = append(c.interceptors, i)
+		return c
+	}
+}
+
+func newConfig(options []Option) config {
+	c := config{}
+	for _, o := range options {
+		c = o(c)
+	}
+	return c
+}
+
+// intercept runs f through the interceptors.
+func (set *IPSet) intercept(op Operation, f func() error) error {
+	next := f
+	for i := len(set.interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := set.interceptors[i], next
+		next = func() error {
+			return interceptor(op, inner)
+		}
+	}
+	return next()
+}
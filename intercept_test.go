@@ -0,0 +1,102 @@
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestNewOperation(t *testing.T) {
+	cases := []struct {
+		command  string
+		expected Operation
+	}{
+		{"add bl 1.2.3.4 timeout 60", Operation{Kind: OpAdd, Set: "bl", Element: "1.2.3.4"}},
+		{"-exist -D bl 10.0.0.0/8", Operation{Kind: OpDel, Set: "bl", Element: "10.0.0.0/8"}},
+		{"create bl hash:ip family inet", Operation{Kind: OpCreate, Set: "bl"}},
+		{"swap a b", Operation{Kind: OpSwap, Set: "a"}},
+		{"save", Operation{Kind: OpSave}},
+		{"version", Operation{Kind: OpOther, Set: ""}},
+		{"", Operation{Kind: OpOther}},
+	}
+
+	for _, c := range cases {
+		c.expected.Command = c.command
+		if op := newOperation(strings.Fields(c.command), c.command); op != c.expected {
+			t.Errorf("'%s': expected %+v, was %+v", c.command, c.expected, op)
+		}
+	}
+}
+
+func TestInterceptorChain(t *testing.T) {
+	var log []string
+	logger := func(name string) Interceptor {
+		return func(op Operation, next func() error) error {
+			log = append(log, fmt.Sprintf("%s before %s %s %s", name, op.Kind, op.Set, op.Element))
+			err := next()
+			log = append(log, fmt.Sprintf("%s after %v", name, err))
+			return err
+		}
+	}
+
+	set := New(OptionInterceptor(logger("outer")), OptionInterceptor(logger("inner")))
+	defer set.Close()
+
+	set.SetDryRun(true)
+	if _, err := set.AddElement(noSuchSet, net.ParseIP("1.2.3.4")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := "outer before add bl2 1.2.3.4|inner before add bl2 1.2.3.4|inner after <nil>|outer after <nil>"
+	if s := strings.Join(log, "|"); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+}
+
+func TestInterceptorDenies(t *testing.T) {
+	errDenied := errors.New("denied")
+	deny := func(op Operation, next func() error) error {
+		if op.Kind == OpDestroy || op.Kind == OpFlush {
+			return errDenied
+		}
+		return next()
+	}
+
+	set := New(OptionInterceptor(deny))
+	defer set.Close()
+
+	set.SetDryRun(true)
+
+	if err := set.Destroy(namedSetV4); !errors.Is(err, errDenied) {
+		t.Errorf("error should be errDenied, was %v", err)
+	}
+	if err := set.Restore(strings.NewReader("add bl4 1.2.3.5\nflush bl4\n")); !errors.Is(err, errDenied) {
+		t.Errorf("error should be errDenied, was %v", err)
+	}
+
+	if plan := set.Plan(); plan != "add bl4 1.2.3.5\n" {
+		t.Errorf("expected only the add planned, was %q", plan)
+	}
+}
+
+func TestInterceptorSeesError(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	var seen error
+	set := New(OptionInterceptor(func(op Operation, next func() error) error {
+		seen = next()
+		return seen
+	}))
+	defer set.Close()
+
+	err := set.Destroy(noSuchSet)
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("error should be ErrSetNotFound, was %v", err)
+	}
+	if !errors.Is(seen, ErrSetNotFound) {
+		t.Errorf("interceptor should see ErrSetNotFound, was %v", seen)
+	}
+}
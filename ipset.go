@@ -13,20 +13,28 @@ package ipset
 #include <libipset/ipset.h>
 
 int goips_custom_printf(struct ipset *ipset, void *p);
+int goips_parse_stream(struct ipset *ipset, char *buf, size_t len);
 */
 import "C"
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 	"unsafe"
 
 	gopointer "github.com/mattn/go-pointer"
 )
 
+// maxSetNameLen is IPSET_MAXNAMELEN less the terminating nul.
+const maxSetNameLen = C.IPSET_MAXNAMELEN - 1
+
 type errorLevel int
 
 const (
@@ -39,6 +47,7 @@ const (
 
 type cmdError struct {
 	Level   errorLevel
+	Status  int
 	Message string
 }
 
@@ -60,43 +69,158 @@ func (err *cmdError) Error() string {
 
 var ErrSetNotFound = errors.New("set not found")
 var ErrSetExists = errors.New("set exists")
+var ErrElementOutOfRange = errors.New("element out of range")
+var ErrInvalidElement = errors.New("invalid element")
+var ErrInvalidSetName = errors.New("invalid set name")
+var ErrVersionProblem = errors.New("version problem")
+
+// VersionError is returned when libipset and the kernel do not support the
+// same protocol, or the kernel does not support a set type or revision.
+// It matches ErrVersionProblem.
+type VersionError struct {
+	Message string
+}
+
+func (e *VersionError) Error() string {
+	return e.Message
+}
+
+func (e *VersionError) Is(target error) bool {
+	return target == ErrVersionProblem
+}
+
+// versionMessages are parts of the messages libipset reports for version
+// problems detected by the kernel.
+var versionMessages = []string{
+	"Kernel support protocol versions",
+	"Kernel and userspace incompatible",
+	"not supported by userspace",
+	"set type not supported",
+}
+
+func isVersionProblem(cmderr *cmdError) bool {
+	if cmderr.Status == C.IPSET_VERSION_PROBLEM {
+		return true
+	}
+	for _, m := range versionMessages {
+		if strings.Contains(cmderr.Message, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateSetName checks that name can be used as a set name: 1 to 31
+// characters of letters, digits and _ . : + = @ -, not starting with -.
+// Set names are checked by every method before anything is sent to
+// libipset.
+func ValidateSetName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name: %w", ErrInvalidSetName)
+	}
+	if len(name) > maxSetNameLen {
+		return fmt.Errorf("'%s' longer than %d characters: %w", name, maxSetNameLen, ErrInvalidSetName)
+	}
+	if name[0] == '-' {
+		return fmt.Errorf("'%s' starts with -: %w", name, ErrInvalidSetName)
+	}
+	for _, c := range name {
+		if !isSetNameChar(c) {
+			return fmt.Errorf("%q contains %q: %w", name, c, ErrInvalidSetName)
+		}
+	}
+	return nil
+}
+
+func isSetNameChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_.:+=@-", c)
+}
+
+func validateSetNames(names ...string) error {
+	for _, name := range names {
+		if err := ValidateSetName(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 type IPSet struct {
+	mu            sync.Mutex
 	ptr           *C.struct_ipset
 	selfptr       unsafe.Pointer
+	ns            *nsThread
 	recentError   *cmdError
 	recentMessage string
+	dryRun        bool
+	plan          []string
+	interceptors  []Interceptor
 }
 
 type Info struct {
-	Name    string
-	Type    string
-	Family  string
-	Timeout *int
+	Name       string     `json:"name"`
+	Type       SetType    `json:"type"`
+	Family     Family     `json:"family,omitempty"`
+	Timeout    *int       `json:"timeout,omitempty"`
+	Size       *int       `json:"size,omitempty"`
+	IPRange    *IPRange   `json:"ip_range,omitempty"`
+	PortRange  *PortRange `json:"port_range,omitempty"`
+	Netmask    *int       `json:"netmask,omitempty"`
+	Bitmask    *net.IP    `json:"bitmask,omitempty"`
+	HashSize   *int       `json:"hashsize,omitempty"`
+	MaxElem    *int       `json:"maxelem,omitempty"`
+	BucketSize *int       `json:"bucketsize,omitempty"`
+	MarkMask   *uint32    `json:"markmask,omitempty"`
+	InitVal    *uint32    `json:"initval,omitempty"`
+	Counters   bool       `json:"counters,omitempty"`
+	Comment    bool       `json:"comment,omitempty"`
+	SkbInfo    bool       `json:"skbinfo,omitempty"`
+	ForceAdd   bool       `json:"forceadd,omitempty"`
 }
 
 func init() {
 	C.ipset_load_types()
 }
 
-func New() *IPSet {
-	csetptr := C.ipset_init()
+func New(options ...Option) *IPSet {
 	set := &IPSet{
-		ptr:     csetptr,
-		selfptr: nil,
+		ptr:          nil,
+		selfptr:      nil,
+		interceptors: newConfig(options).interceptors,
 	}
 	set.selfptr = gopointer.Save(set)
-
-	C.goips_custom_printf(set.ptr, set.selfptr)
+	set.init()
 
 	return set
 }
 
+func (set *IPSet) init() {
+	set.ptr = C.ipset_init()
+	C.goips_custom_printf(set.ptr, set.selfptr)
+}
+
 func (set *IPSet) Close() {
-	_ = C.ipset_fini(set.ptr)
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	set.do(func() {
+		_ = C.ipset_fini(set.ptr)
+	})
+	if set.ns != nil {
+		set.ns.stop()
+	}
 	gopointer.Unref(set.selfptr)
 }
 
+// do runs f on the thread that owns the libipset session.
+func (set *IPSet) do(f func()) {
+	if set.ns == nil {
+		f()
+		return
+	}
+	set.ns.run(f)
+}
+
 type CreateOption func(i Info) Info
 
 func CreateOptionTimeout(timeout int) CreateOption {
@@ -106,18 +230,125 @@ func CreateOptionTimeout(timeout int) CreateOption {
 	}
 }
 
-func CreateOptionFamily(family string) CreateOption {
+func CreateOptionFamily(family Family) CreateOption {
 	return func(i Info) Info {
 		i.Family = family
 		return i
 	}
 }
 
+func CreateOptionType(typ SetType) CreateOption {
+	return func(i Info) Info {
+		i.Type = typ
+		return i
+	}
+}
+
+func CreateOptionSize(size int) CreateOption {
+	return func(i Info) Info {
+		i.Size = &size
+		return i
+	}
+}
+
+func CreateOptionIPRange(r IPRange) CreateOption {
+	return func(i Info) Info {
+		i.IPRange = &r
+		return i
+	}
+}
+
+func CreateOptionPortRange(r PortRange) CreateOption {
+	return func(i Info) Info {
+		i.PortRange = &r
+		return i
+	}
+}
+
+// CreateOptionBitmask sets the mask applied to addresses, in address
+// form, such as 255.255.255.0 or ffff:ffff::.
+func CreateOptionBitmask(bitmask net.IP) CreateOption {
+	return func(i Info) Info {
+		i.Bitmask = &bitmask
+		return i
+	}
+}
+
+func CreateOptionNetmask(netmask int) CreateOption {
+	return func(i Info) Info {
+		i.Netmask = &netmask
+		return i
+	}
+}
+
+func CreateOptionHashSize(hashsize int) CreateOption {
+	return func(i Info) Info {
+		i.HashSize = &hashsize
+		return i
+	}
+}
+
+func CreateOptionMaxElem(maxelem int) CreateOption {
+	return func(i Info) Info {
+		i.MaxElem = &maxelem
+		return i
+	}
+}
+
+func CreateOptionBucketSize(bucketsize int) CreateOption {
+	return func(i Info) Info {
+		i.BucketSize = &bucketsize
+		return i
+	}
+}
+
+func CreateOptionMarkMask(markmask uint32) CreateOption {
+	return func(i Info) Info {
+		i.MarkMask = &markmask
+		return i
+	}
+}
+
+func CreateOptionInitVal(initval uint32) CreateOption {
+	return func(i Info) Info {
+		i.InitVal = &initval
+		return i
+	}
+}
+
+func CreateOptionCounters() CreateOption {
+	return func(i Info) Info {
+		i.Counters = true
+		return i
+	}
+}
+
+func CreateOptionComment() CreateOption {
+	return func(i Info) Info {
+		i.Comment = true
+		return i
+	}
+}
+
+func CreateOptionSkbInfo() CreateOption {
+	return func(i Info) Info {
+		i.SkbInfo = true
+		return i
+	}
+}
+
+func CreateOptionForceAdd() CreateOption {
+	return func(i Info) Info {
+		i.ForceAdd = true
+		return i
+	}
+}
+
 func (set *IPSet) Create(name string, options ...CreateOption) error {
 	info := Info{
 		Name:    name,
-		Type:    "hash:ip",
-		Family:  "inet",
+		Type:    TypeHashIP,
+		Family:  FamilyInet,
 		Timeout: nil,
 	}
 
@@ -125,66 +356,344 @@ func (set *IPSet) Create(name string, options ...CreateOption) error {
 		info = o(info)
 	}
 
-	cmd := fmt.Sprintf("create %s %s", info.Name, info.Type)
-	cmd = cmd + fmt.Sprintf(" family %s", info.Family)
-	if info.Timeout != nil {
-		cmd = cmd + fmt.Sprintf(" timeout %d", *info.Timeout)
+	return set.create(info)
+}
+
+func (set *IPSet) create(info Info) error {
+	if err := ValidateSetName(info.Name); err != nil {
+		return err
+	}
+	if err := info.Validate(); err != nil {
+		return err
 	}
-	_, _, err := set.Command(cmd)
+
+	_, _, err := set.run(append([]string{"create"}, info.createArgs()...)...)
 
 	if err != nil {
-		return transformCmdError(err)
+		return err
 	}
 
 	return nil
 }
 
+// createArgs returns the name, type and parameters of info in the order
+// used by the create command.
+func (info Info) createArgs() []string {
+	args := []string{info.Name, string(info.Type)}
+	if info.Family != "" && typeHasFamily(info.Type) {
+		args = append(args, "family", string(info.Family))
+	}
+	if info.IPRange != nil {
+		args = append(args, "range", info.IPRange.String())
+	}
+	if info.PortRange != nil {
+		args = append(args, "range", info.PortRange.String())
+	}
+	if info.Netmask != nil {
+		args = append(args, "netmask", strconv.Itoa(*info.Netmask))
+	}
+	if info.Bitmask != nil {
+		args = append(args, "bitmask", info.Bitmask.String())
+	}
+	if info.MarkMask != nil {
+		args = append(args, "markmask", fmt.Sprintf("0x%08x", *info.MarkMask))
+	}
+	if info.HashSize != nil {
+		args = append(args, "hashsize", strconv.Itoa(*info.HashSize))
+	}
+	if info.MaxElem != nil {
+		args = append(args, "maxelem", strconv.Itoa(*info.MaxElem))
+	}
+	if info.BucketSize != nil {
+		args = append(args, "bucketsize", strconv.Itoa(*info.BucketSize))
+	}
+	if info.InitVal != nil {
+		args = append(args, "initval", fmt.Sprintf("0x%08x", *info.InitVal))
+	}
+	if info.Size != nil {
+		args = append(args, "size", strconv.Itoa(*info.Size))
+	}
+	if info.Timeout != nil {
+		args = append(args, "timeout", strconv.Itoa(*info.Timeout))
+	}
+	if info.Counters {
+		args = append(args, "counters")
+	}
+	if info.Comment {
+		args = append(args, "comment")
+	}
+	if info.SkbInfo {
+		args = append(args, "skbinfo")
+	}
+	if info.ForceAdd {
+		args = append(args, "forceadd")
+	}
+	return args
+}
+
 func (set *IPSet) Destroy(name string) error {
-	_, _, err := set.Command(fmt.Sprintf("destroy %s", name))
+	if err := ValidateSetName(name); err != nil {
+		return err
+	}
+
+	_, _, err := set.run("destroy", name)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (set *IPSet) Swap(from, to string) error {
+	if err := validateSetNames(from, to); err != nil {
+		return err
+	}
+
+	_, _, err := set.run("swap", from, to)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (set *IPSet) Rename(from, to string) error {
+	if err := validateSetNames(from, to); err != nil {
+		return err
+	}
+
+	_, _, err := set.run("rename", from, to)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (set *IPSet) Flush(name string) error {
+	if err := ValidateSetName(name); err != nil {
+		return err
+	}
+
+	_, _, err := set.run("flush", name)
 
 	if err != nil {
-		return transformCmdError(err)
+		return err
 	}
 
 	return nil
 }
 
+// Save returns the named set, or all sets if name is empty, in the format
+// read by Restore.
+func (set *IPSet) Save(name string) (string, error) {
+	args := []string{"save"}
+	if name != "" {
+		if err := ValidateSetName(name); err != nil {
+			return "", err
+		}
+		args = append(args, name)
+	}
+
+	_, msg, err := set.run(args...)
+
+	if err != nil {
+		return "", err
+	}
+
+	return msg, nil
+}
+
+// Restore runs the create and add commands read from r, as written by
+// Save. Blank lines, comments and COMMIT lines are skipped. Restoring stops
+// at the first failing line.
+func (set *IPSet) Restore(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") || line == "COMMIT" {
+			continue
+		}
+
+		if _, _, err := set.Command(line); err != nil {
+			return fmt.Errorf("line %d: %w", lineno, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
 func (set *IPSet) Info(name string) (Info, error) {
-	_, msg, err := set.Command(fmt.Sprintf("save %s", name))
+	if err := ValidateSetName(name); err != nil {
+		return Info{}, err
+	}
+
+	_, msg, err := set.run("save", name)
 
 	if err != nil {
-		return Info{}, transformCmdError(err)
+		return Info{}, err
 	}
 
 	// create bl hash:ip family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0xd263dc02
 	// ...
-	lines := strings.Split(msg, "\n")
-	fields := strings.Fields(lines[0])
+	line, _, _ := strings.Cut(msg, "\n")
+	info, params, err := splitCreateLine(line)
+	if err != nil {
+		return Info{}, err
+	}
 
-	info := Info{}
+	// Newer kernels may save parameters unknown here, which are skipped.
+	info, _ = parseCreateParams(info, params)
+	return info, nil
+}
+
+// ParseCreateLine parses a create line, as written by Save or by
+// Info.CreateLine, into the Info it describes. Unlike Info, which skips
+// what it doesn't know, it fails on any parameter it can't render back
+// exactly.
+func ParseCreateLine(line string) (Info, error) {
+	info, params, err := splitCreateLine(line)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := ValidateSetName(info.Name); err != nil {
+		return Info{}, err
+	}
 
-	info.Name = fields[1]
-	info.Type = fields[2]
+	info, err = parseCreateParams(info, params)
+	if err != nil {
+		return Info{}, fmt.Errorf("create line '%s': %w", line, err)
+	}
+	if info.Family != "" && !typeHasFamily(info.Type) {
+		return Info{}, fmt.Errorf("create line '%s': %s sets don't take a family", line, info.Type)
+	}
+
+	return info, nil
+}
 
-	for i := 3; i+1 < len(fields); i++ {
+// splitCreateLine returns the name and type of a create line, and the
+// parameters following them.
+func splitCreateLine(line string) (Info, []string, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != "create" {
+		return Info{}, nil, fmt.Errorf("not a create line: '%s'", line)
+	}
+
+	return Info{Name: fields[1], Type: SetType(fields[2])}, fields[3:], nil
+}
+
+// parseCreateParams sets the fields of info from the parameters following
+// the type in a create line or in the Header line of a listing. It sets
+// what it can and returns an error for every parameter that is unknown,
+// repeated or has an invalid value.
+func parseCreateParams(info Info, fields []string) (Info, error) {
+	var errs []error
+	seen := make(map[string]bool, len(fields))
+
+	for i := 0; i < len(fields); i++ {
 		key := fields[i]
-		val := fields[i+1]
+		val := ""
+		if !isCreateFlag(key) {
+			if i+1 == len(fields) {
+				errs = append(errs, fmt.Errorf("parameter '%s' needs a value", key))
+				break
+			}
+			val = fields[i+1]
+			i++
+		}
+
+		if seen[key] {
+			errs = append(errs, fmt.Errorf("repeated parameter '%s'", key))
+			continue
+		}
+		seen[key] = true
 
+		var err error
 		switch key {
 		case "family":
-			info.Family = val
+			info.Family = Family(val)
+			err = info.Family.Validate()
 		case "timeout":
-			if n, err := strconv.Atoi(val); err == nil {
-				info.Timeout = &n
+			info.Timeout, err = parseCreateInt(val)
+		case "size":
+			info.Size, err = parseCreateInt(val)
+		case "range":
+			if info.Type == TypeBitmapPort {
+				var r PortRange
+				if r, err = ParsePortRange(val); err == nil {
+					info.PortRange = &r
+				}
+			} else {
+				var r IPRange
+				if r, err = ParseIPRange(val); err == nil {
+					info.IPRange = &r
+				}
 			}
+		case "netmask":
+			info.Netmask, err = parseCreateInt(val)
+		case "bitmask":
+			if mask := net.ParseIP(val); mask != nil {
+				info.Bitmask = &mask
+			} else {
+				err = errors.New("invalid address")
+			}
+		case "hashsize":
+			info.HashSize, err = parseCreateInt(val)
+		case "maxelem":
+			info.MaxElem, err = parseCreateInt(val)
+		case "bucketsize":
+			info.BucketSize, err = parseCreateInt(val)
+		case "markmask":
+			info.MarkMask, err = parseCreateMask(val)
+		case "initval":
+			info.InitVal, err = parseCreateMask(val)
+		case "counters":
+			info.Counters = true
+		case "comment":
+			info.Comment = true
+		case "skbinfo":
+			info.SkbInfo = true
+		case "forceadd":
+			info.ForceAdd = true
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s '%s': %w", key, val, err))
 		}
 	}
 
-	return info, nil
+	return info, errors.Join(errs...)
+}
+
+func parseCreateInt(s string) (*int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// parseCreateMask parses a 32 bit value, such as a markmask, that is saved
+// in hex.
+func parseCreateMask(s string) (*uint32, error) {
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return nil, err
+	}
+	m := uint32(n)
+	return &m, nil
 }
 
 func (set *IPSet) Add(name string, addr net.IP) (bool, error) {
-	return set.add(fmt.Sprintf("add %s %s", name, addr.String()))
+	return set.add(name, addr.String())
 }
 
 func (set *IPSet) Add6(name string, addr net.IP) (bool, error) {
@@ -196,26 +705,102 @@ func (set *IPSet) Add6(name string, addr net.IP) (bool, error) {
 		addrString = addr.String()
 	}
 
-	return set.add(fmt.Sprintf("add %s %s", name, addrString))
+	return set.add(name, addrString)
 }
 
-func (set *IPSet) add(cmd string) (bool, error) {
-	r, _, err := set.Command(cmd)
+func (set *IPSet) add(name string, entry ...string) (bool, error) {
+	if err := ValidateSetName(name); err != nil {
+		return false, err
+	}
 
+	r, _, err := set.run(append([]string{"add", name}, entry...)...)
+
+	return r == 0, err
+}
+
+func (set *IPSet) AddElement(name string, elem Element, options ...EntryOption) (bool, error) {
+	if err := validateElement(elem); err != nil {
+		return false, err
+	}
+
+	entry := newEntry(elem, options)
+	if err := entry.validate(); err != nil {
+		return false, err
+	}
+
+	return set.add(name, entry.args()...)
+}
+
+// AddElements adds every element of elems with the same options. They are
+// sent to libipset as a single restore stream, which it batches into few
+// netlink messages, rather than as one command each. Elements already in
+// the set are skipped. Every element is validated before anything is
+// sent; should the kernel refuse one, those before it stay added and their
+// number is returned.
+func (set *IPSet) AddElements(name string, elems []Element, options ...EntryOption) (int, error) {
+	if err := ValidateSetName(name); err != nil {
+		return 0, err
+	}
+	if len(elems) == 0 {
+		return 0, nil
+	}
+
+	var stream strings.Builder
+	for _, elem := range elems {
+		if err := validateElement(elem); err != nil {
+			return 0, err
+		}
+		entry := newEntry(elem, options)
+		if err := entry.validate(); err != nil {
+			return 0, err
+		}
+		stream.WriteString(formatCommand(append([]string{"add", name}, entry.args()...)))
+		stream.WriteString("\n")
+	}
+
+	_, _, err := set.restore([]string{"add", name}, stream.String())
 	if err != nil {
-		if strings.Contains(err.Error(), "Element cannot be added to the set: it's already added") {
-			return true, nil
+		n := failedLine(err) - 1
+		if n < 0 {
+			return 0, err
 		}
+		return n, fmt.Errorf("%s: %w", elems[n], err)
+	}
+
+	return len(elems), nil
+}
+
+// failedLine returns the line of a restore stream an error is reported
+// for, or zero if it isn't.
+func failedLine(err error) int {
+	var cmderr *cmdError
+	var n int
+	if errors.As(err, &cmderr) {
+		fmt.Sscanf(cmderr.Message, "Error in line %d:", &n)
+	}
+	return n
+}
 
-		return r == 0, transformCmdError(err)
+func (set *IPSet) DelElement(name string, elem Element) (bool, error) {
+	if err := validateElement(elem); err != nil {
+		return false, err
 	}
 
-	return r == 0, nil
+	return set.del(name, elementArgs(elem)...)
+}
+
+func (set *IPSet) del(name string, elem ...string) (bool, error) {
+	if err := ValidateSetName(name); err != nil {
+		return false, err
+	}
+
+	r, _, err := set.run(append([]string{"del", name}, elem...)...)
+
+	return r == 0, err
 }
 
 func (set *IPSet) Test(name string, addr net.IP) (bool, error) {
-	cmd := fmt.Sprintf("test %s %s", name, addr.String())
-	return set.test(cmd)
+	return set.test(name, addr.String())
 }
 
 func (set *IPSet) Test6(name string, addr net.IP) (bool, error) {
@@ -227,47 +812,197 @@ func (set *IPSet) Test6(name string, addr net.IP) (bool, error) {
 		addrString = addr.String()
 	}
 
-	cmd := fmt.Sprintf("test %s %s", name, addrString)
-	return set.test(cmd)
+	return set.test(name, addrString)
 }
 
-func (set *IPSet) test(cmd string) (bool, error) {
-	r, _, err := set.Command(cmd)
+func (set *IPSet) TestElement(name string, elem Element, options ...EntryOption) (bool, error) {
+	if err := validateElement(elem); err != nil {
+		return false, err
+	}
 
-	// First transform.
-	// If the transformed error is still a cmdError it will be dealt with
-	// accordingly. Specifically, if the error is a warning or info on match
-	// or no match it will be ignored, etc. If on the other hand the error
-	// is translated to an explicit error then we know we have a "genuine"
-	//failure.
-	err = transformCmdError(err)
+	entry := newEntry(elem, options)
+	if err := entry.validate(); err != nil {
+		return false, err
+	}
 
-	var cmderr *cmdError
-	if errors.As(err, &cmderr) {
-		if cmderr.Level >= errorLevelError {
-			return false, err
-		}
-	} else if err != nil {
+	return set.test(name, entry.args()...)
+}
+
+func (set *IPSet) test(name string, entry ...string) (bool, error) {
+	if err := ValidateSetName(name); err != nil {
+		return false, err
+	}
+
+	r, _, err := set.run(append([]string{"test", name}, entry...)...)
+
+	if err != nil {
 		return false, err
 	}
 
 	return r == 0, nil
 }
 
+// Members returns the elements of the set in the order they are saved by
+// the kernel. For list:set sets this is the matching order of the member
+// sets.
+func (set *IPSet) Members(name string) ([]string, error) {
+	entries, err := set.Entries(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, len(entries))
+	for i, e := range entries {
+		members[i] = e.Element.String()
+	}
+
+	return members, nil
+}
+
+// Entries is like Members but includes the remaining timeout, counters,
+// comment and flags of each element.
+func (set *IPSet) Entries(name string) ([]Entry, error) {
+	if err := ValidateSetName(name); err != nil {
+		return nil, err
+	}
+
+	_, msg, err := set.run("save", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, line := range strings.Split(msg, "\n") {
+		if _, entry, ok := parseEntry(line); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Command runs a single line of ipset command syntax, as in a restore
+// file. The line is split by libipset, so it must not be built from
+// untrusted input; the other methods pass their arguments separately and
+// validate set names.
 func (set *IPSet) Command(command string) (int, string, error) {
-	ccmd := C.CString(command)
-	defer C.free(unsafe.Pointer(ccmd))
+	return set.exec(strings.Fields(command), command, false, func() C.int {
+		ccmd := C.CString(command)
+		defer C.free(unsafe.Pointer(ccmd))
 
-	if set.ptr != nil {
-		_ = C.ipset_fini(set.ptr)
-		set.ptr = C.ipset_init()
-		C.goips_custom_printf(set.ptr, set.selfptr)
+		return C.ipset_parse_line(set.ptr, ccmd)
+	})
+}
+
+// run runs the command given as separate arguments, which libipset takes
+// as they are, so that no argument can be split into several.
+func (set *IPSet) run(args ...string) (int, string, error) {
+	return set.exec(args, formatCommand(args), true, func() C.int {
+		argv := make([]*C.char, len(args)+2)
+		argv[0] = C.CString("ipset")
+		for i, arg := range args {
+			argv[i+1] = C.CString(arg)
+		}
+
+		// libipset may reorder argv, so free from a copy.
+		cargs := make([]*C.char, len(argv))
+		copy(cargs, argv)
+		defer func() {
+			for _, p := range cargs {
+				C.free(unsafe.Pointer(p))
+			}
+		}()
+
+		return C.ipset_parse_argv(set.ptr, C.int(len(args)+1), &argv[0])
+	})
+}
+
+// restore runs the lines of ipset command syntax in stream through
+// libipset as a single restore stream. args are the words the commands
+// have in common, describing them to the interceptors.
+func (set *IPSet) restore(args []string, stream string) (int, string, error) {
+	return set.exec(args, strings.TrimSuffix(stream, "\n"), true, func() C.int {
+		cstream := C.CString(stream)
+		defer C.free(unsafe.Pointer(cstream))
+
+		return C.goips_parse_stream(set.ptr, cstream, C.size_t(len(stream)))
+	})
+}
+
+// exec runs parse, which hands a command to libipset, through the
+// interceptors and in a fresh session. args are the words of command.
+// Errors are transformed, and with settle also settled as the methods
+// return them, before the interceptors see them.
+func (set *IPSet) exec(args []string, command string, settle bool, parse func() C.int) (int, string, error) {
+	op := newOperation(args, command)
+
+	r, msg := -1, ""
+	run := func() error {
+		var err error
+		r, msg, err = set.execLocked(args, command, parse)
+		if settle {
+			r, err = settleResult(op.Kind, r, err)
+		} else {
+			err = transformCmdError(err)
+		}
+		return err
 	}
 
-	set.recentError = nil
-	set.recentMessage = ""
+	if len(set.interceptors) == 0 {
+		err := run()
+		return r, msg, err
+	}
+
+	err := set.intercept(op, run)
 
-	r := int(C.ipset_parse_line(set.ptr, ccmd))
+	return r, msg, err
+}
+
+// settleResult maps the result of a command to what the methods return.
+// Adding an element that is already added and deleting one that is not
+// succeed, a test miss is a warning rather than an error, and other errors
+// are transformed into the package errors.
+func settleResult(kind OpKind, r int, err error) (int, error) {
+	var cmderr *cmdError
+	if !errors.As(err, &cmderr) {
+		return r, err
+	}
+
+	switch {
+	case kind == OpAdd && strings.Contains(cmderr.Message, "Element cannot be added to the set: it's already added"):
+		return 0, nil
+	case kind == OpDel && strings.Contains(cmderr.Message, "Element cannot be deleted from the set: it's not added"):
+		return 0, nil
+	case kind == OpTest && cmderr.Level < errorLevelError:
+		return r, nil
+	}
+
+	return r, transformCmdError(err)
+}
+
+func (set *IPSet) execLocked(args []string, command string, parse func() C.int) (int, string, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	if set.dryRun && !isReadOnlyCommand(args) {
+		set.plan = append(set.plan, command)
+		return 0, "", nil
+	}
+
+	var r int
+	set.do(func() {
+		if set.ptr != nil {
+			_ = C.ipset_fini(set.ptr)
+			set.init()
+		}
+
+		set.recentError = nil
+		set.recentMessage = ""
+
+		r = int(parse())
+	})
 
 	if set.recentError != nil {
 		err := set.recentError
@@ -275,30 +1010,117 @@ func (set *IPSet) Command(command string) (int, string, error) {
 		return r, "", err
 	}
 
-	msg := set.recentMessage
+	msg := strings.TrimSpace(set.recentMessage)
 	set.recentMessage = ""
 
 	return r, msg, nil
 }
 
+// formatCommand renders args as a line of ipset command syntax, quoting
+// arguments that contain whitespace.
+func formatCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.IndexFunc(arg, unicode.IsSpace) >= 0 {
+			arg = "\"" + arg + "\""
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
 func (set Info) String() string {
-	to := ""
-	if set.Timeout != nil {
-		to = fmt.Sprintf(" timeout %d", *set.Timeout)
+	return fmt.Sprintf("<create %s>", strings.Join(set.createArgs(), " "))
+}
+
+// CreateLine returns set as the create line of a save, which Restore takes
+// and ParseCreateLine reads back.
+func (set Info) CreateLine() string {
+	return formatCommand(append([]string{"create"}, set.createArgs()...))
+}
+
+// CheckElement verifies that elem fits sets described by set: that its
+// components and family match the set type, and that it falls within the
+// range of a bitmap set. Elements of sets of unknown type and without a
+// range always pass.
+func (set Info) CheckElement(elem Element) error {
+	if err := set.checkShape(elem); err != nil {
+		return err
+	}
+
+	var inRange bool
+
+	switch e := elem.(type) {
+	case net.IP:
+		inRange = set.IPRange == nil || set.IPRange.Contains(e)
+	case IPMAC:
+		inRange = set.IPRange == nil || set.IPRange.Contains(e.IP)
+	case Port:
+		inRange = set.PortRange == nil || set.PortRange.Contains(int(e))
+	default:
+		return nil
+	}
+
+	if !inRange {
+		return fmt.Errorf("%s: %w", elem, ErrElementOutOfRange)
 	}
-	return fmt.Sprintf("<create %s %s family %s%s>", set.Name, set.Type, set.Family, to)
+
+	return nil
+}
+
+// isCreateFlag reports whether key is a create parameter without a value.
+func isCreateFlag(key string) bool {
+	switch key {
+	case "counters", "comment", "skbinfo", "forceadd":
+		return true
+	}
+	return false
+}
+
+func parseIntPtr(s string) *int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// typeHasFamily reports whether sets of the given type take a family
+// parameter on creation. Most hash types do; hash:mac, bitmap and list
+// types reject it.
+func typeHasFamily(typ SetType) bool {
+	if caps, ok := typ.Capabilities(); ok {
+		return caps.takes("family")
+	}
+	return strings.HasPrefix(string(typ), "hash:")
 }
 
 func transformCmdError(err error) error {
 	var cmderr *cmdError
 	if errors.As(err, &cmderr) {
-		if cmderr.Message == "The set with the given name does not exist" {
+		if strings.HasSuffix(cmderr.Message, "The set with the given name does not exist") {
 			return errors.Join(cmderr, ErrSetNotFound)
 		}
 
-		if cmderr.Message == "Set cannot be created: set with the same name already exists" {
+		if strings.HasSuffix(cmderr.Message, "Set cannot be created: set with the same name already exists") {
+			return errors.Join(cmderr, ErrSetExists)
+		}
+
+		if strings.Contains(cmderr.Message, "Set cannot be renamed: a set with the new name already exists") {
 			return errors.Join(cmderr, ErrSetExists)
 		}
+
+		if strings.Contains(cmderr.Message, "Sets cannot be swapped: the second set does not exist") {
+			return errors.Join(cmderr, ErrSetNotFound)
+		}
+
+		if strings.Contains(cmderr.Message, "Element is out of the range of the set") {
+			return errors.Join(cmderr, ErrElementOutOfRange)
+		}
+
+		if isVersionProblem(cmderr) {
+			return errors.Join(cmderr, &VersionError{Message: cmderr.Message})
+		}
 	}
 
 	return err
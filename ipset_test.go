@@ -1,22 +1,31 @@
 package ipset
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
+	"runtime"
 	"strings"
 	"testing"
+
+	"golang.org/x/sys/unix"
 )
 
 const (
 	namedSetV4 = "bl4"
 	namedSetV6 = "bl6"
 	noSuchSet  = "bl2"
+	listSet    = "bll"
 )
 
 func setup(t *testing.T) func(t *testing.T) {
+	requireKernel(t)
+
 	set := New()
 	defer set.Close()
 
+	set.Destroy(listSet)
 	set.Destroy(namedSetV4)
 	set.Destroy(noSuchSet)
 	set.Destroy(namedSetV6)
@@ -31,6 +40,7 @@ func setup(t *testing.T) func(t *testing.T) {
 		set := New()
 		defer set.Close()
 
+		set.Destroy(listSet)
 		set.Destroy(namedSetV4)
 		set.Destroy(noSuchSet)
 		set.Destroy(namedSetV6)
@@ -537,3 +547,879 @@ func TestAddIPv4OnIPv6(t *testing.T) {
 		t.Errorf("Expected parse error on IPv4 address but got '%v'", err)
 	}
 }
+
+func TestCreateListSet(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	err := set.Create(listSet, CreateOptionType("list:set"), CreateOptionSize(4))
+
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	info, err := set.Info(listSet)
+	if err != nil {
+		t.Fatalf("expected set '%s', got error: %v", listSet, err)
+	}
+
+	if info.Type != "list:set" {
+		t.Errorf("expected type 'list:set', was '%s'", info.Type)
+	}
+	if info.Family != "" {
+		t.Errorf("expected no family, was '%s'", info.Family)
+	}
+	if info.Size == nil {
+		t.Errorf("expected size 4, was nil")
+	} else if *info.Size != 4 {
+		t.Errorf("expected size 4, was '%v'", *info.Size)
+	}
+}
+
+func TestListSetMembersOrdered(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	if err := set.Create(listSet, CreateOptionType("list:set")); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	for _, m := range []SetMember{
+		{Name: namedSetV6},
+		{Name: namedSetV4, Before: namedSetV6},
+	} {
+		ok, err := set.AddElement(listSet, m)
+		if err != nil {
+			t.Fatalf("add member %s: unexpected error %v", m, err)
+		}
+		if !ok {
+			t.Errorf("add member %s: expected ok", m)
+		}
+	}
+
+	members, err := set.Members(listSet)
+	if err != nil {
+		t.Fatalf("unexpected error listing members: %v", err)
+	}
+
+	expected := []string{namedSetV4, namedSetV6}
+	if strings.Join(members, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected members %v, was %v", expected, members)
+	}
+
+	found, err := set.TestElement(listSet, SetMember{Name: namedSetV4, Before: namedSetV6})
+	if err != nil {
+		t.Errorf("unexpected error testing member: %v", err)
+	}
+	if !found {
+		t.Errorf("expected %s before %s in %s", namedSetV4, namedSetV6, listSet)
+	}
+
+	found, err = set.TestElement(listSet, SetMember{Name: namedSetV4, After: namedSetV6})
+	if err != nil {
+		t.Errorf("unexpected error testing member: %v", err)
+	}
+	if found {
+		t.Errorf("did not expect %s after %s in %s", namedSetV4, namedSetV6, listSet)
+	}
+}
+
+func TestListSetDelMember(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	if err := set.Create(listSet, CreateOptionType("list:set")); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := set.AddElement(listSet, SetMember{Name: namedSetV4}); err != nil {
+		t.Fatalf("add member failed: %v", err)
+	}
+
+	ok, err := set.DelElement(listSet, SetMember{Name: namedSetV4})
+	if err != nil {
+		t.Fatalf("unexpected error deleting member: %v", err)
+	}
+	if !ok {
+		t.Errorf("expected ok")
+	}
+
+	found, err := set.TestElement(listSet, SetMember{Name: namedSetV4})
+	if err != nil {
+		t.Errorf("unexpected error testing member: %v", err)
+	}
+	if found {
+		t.Errorf("member %s not expected in %s but was", namedSetV4, listSet)
+	}
+
+	ok, err = set.DelElement(listSet, SetMember{Name: namedSetV4})
+	if err != nil {
+		t.Fatalf("expected no error deleting missing member, got %v", err)
+	}
+	if !ok {
+		t.Errorf("delete of missing member failed")
+	}
+}
+
+func TestSetMemberString(t *testing.T) {
+	cases := []struct {
+		member   SetMember
+		expected string
+	}{
+		{SetMember{Name: "a"}, "a"},
+		{SetMember{Name: "a", Before: "b"}, "a before b"},
+		{SetMember{Name: "a", After: "b"}, "a after b"},
+	}
+
+	for _, c := range cases {
+		if s := c.member.String(); s != c.expected {
+			t.Errorf("expected '%s', was '%s'", c.expected, s)
+		}
+	}
+}
+
+func TestCreateBitmapIP(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	_, cidr, _ := net.ParseCIDR("10.1.0.0/16")
+	err := set.Create(noSuchSet, CreateOptionType("bitmap:ip"), CreateOptionIPRange(IPRangeFromNet(cidr)), CreateOptionNetmask(24))
+
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	info, err := set.Info(noSuchSet)
+	if err != nil {
+		t.Fatalf("expected set '%s', got error: %v", noSuchSet, err)
+	}
+
+	if info.Type != "bitmap:ip" {
+		t.Errorf("expected type 'bitmap:ip', was '%s'", info.Type)
+	}
+	if info.IPRange == nil {
+		t.Fatalf("expected range, was nil")
+	}
+	if info.IPRange.String() != "10.1.0.0-10.1.255.255" {
+		t.Errorf("expected range '10.1.0.0-10.1.255.255', was '%s'", info.IPRange)
+	}
+	if info.Netmask == nil {
+		t.Errorf("expected netmask 24, was nil")
+	} else if *info.Netmask != 24 {
+		t.Errorf("expected netmask 24, was '%v'", *info.Netmask)
+	}
+
+	ok, err := set.Add(noSuchSet, net.IPv4(10, 1, 2, 3))
+	if err != nil {
+		t.Errorf("expected no error on add, got '%v'", err)
+	}
+	if !ok {
+		t.Errorf("expected ok")
+	}
+
+	_, err = set.Add(noSuchSet, net.IPv4(10, 2, 0, 1))
+	if !errors.Is(err, ErrElementOutOfRange) {
+		t.Errorf("error should be ErrElementOutOfRange, was %v", err)
+	}
+}
+
+func TestCreateBitmapIPMAC(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	r := IPRange{First: net.IPv4(192, 168, 0, 0), Last: net.IPv4(192, 168, 0, 255)}
+	err := set.Create(noSuchSet, CreateOptionType("bitmap:ip,mac"), CreateOptionIPRange(r))
+
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	elem := IPMAC{IP: net.IPv4(192, 168, 0, 1), MAC: mac}
+	ok, err := set.AddElement(noSuchSet, elem)
+	if err != nil {
+		t.Errorf("expected no error on add, got '%v'", err)
+	}
+	if !ok {
+		t.Errorf("expected ok")
+	}
+
+	found, err := set.TestElement(noSuchSet, elem)
+	if err != nil {
+		t.Errorf("element %s: unexpected error %v", elem, err)
+	}
+	if !found {
+		t.Errorf("element %s expected in the set %s", elem, noSuchSet)
+	}
+}
+
+func TestCreateBitmapPort(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	err := set.Create(noSuchSet, CreateOptionType("bitmap:port"), CreateOptionPortRange(PortRange{First: 0, Last: 1024}))
+
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	info, err := set.Info(noSuchSet)
+	if err != nil {
+		t.Fatalf("expected set '%s', got error: %v", noSuchSet, err)
+	}
+	if info.PortRange == nil {
+		t.Fatalf("expected port range, was nil")
+	}
+	if *info.PortRange != (PortRange{First: 0, Last: 1024}) {
+		t.Errorf("expected port range 0-1024, was '%s'", info.PortRange)
+	}
+
+	ok, err := set.AddElement(noSuchSet, Port(443))
+	if err != nil {
+		t.Errorf("expected no error on add, got '%v'", err)
+	}
+	if !ok {
+		t.Errorf("expected ok")
+	}
+
+	_, err = set.AddElement(noSuchSet, Port(8080))
+	if !errors.Is(err, ErrElementOutOfRange) {
+		t.Errorf("error should be ErrElementOutOfRange, was %v", err)
+	}
+}
+
+func TestInfoCheckElement(t *testing.T) {
+	ipRange := IPRange{First: net.IPv4(10, 0, 0, 0), Last: net.IPv4(10, 0, 255, 255)}
+	portRange := PortRange{First: 1000, Last: 2000}
+
+	cases := []struct {
+		info    Info
+		elem    Element
+		inRange bool
+	}{
+		{Info{IPRange: &ipRange}, net.IPv4(10, 0, 0, 0), true},
+		{Info{IPRange: &ipRange}, net.IPv4(10, 0, 255, 255), true},
+		{Info{IPRange: &ipRange}, net.IPv4(10, 1, 0, 0), false},
+		{Info{IPRange: &ipRange}, net.IPv4(9, 255, 255, 255), false},
+		{Info{IPRange: &ipRange}, IPMAC{IP: net.IPv4(10, 0, 1, 1)}, true},
+		{Info{IPRange: &ipRange}, IPMAC{IP: net.IPv4(11, 0, 1, 1)}, false},
+		{Info{PortRange: &portRange}, Port(1000), true},
+		{Info{PortRange: &portRange}, Port(2001), false},
+		{Info{}, net.IPv4(1, 2, 3, 4), true},
+	}
+
+	for _, c := range cases {
+		err := c.info.CheckElement(c.elem)
+		if c.inRange && err != nil {
+			t.Errorf("element %s: unexpected error %v", c.elem, err)
+		}
+		if !c.inRange && !errors.Is(err, ErrElementOutOfRange) {
+			t.Errorf("element %s: error should be ErrElementOutOfRange, was %v", c.elem, err)
+		}
+	}
+}
+
+func TestParseIPRange(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"10.0.0.0-10.0.0.255", "10.0.0.0-10.0.0.255"},
+		{"10.0.0.0/24", "10.0.0.0-10.0.0.255"},
+		{"2001:db8::/127", "2001:db8::-2001:db8::1"},
+	}
+
+	for _, c := range cases {
+		r, err := ParseIPRange(c.input)
+		if err != nil {
+			t.Errorf("range %s: unexpected error %v", c.input, err)
+			continue
+		}
+		if r.String() != c.expected {
+			t.Errorf("range %s: expected '%s', was '%s'", c.input, c.expected, r)
+		}
+	}
+
+	for _, s := range []string{"10.0.0.0-nope", "10.0.0.9-10.0.0.1", "10.0.0.1-::1"} {
+		if _, err := ParseIPRange(s); err == nil {
+			t.Errorf("%s: expected error on invalid range, got nothing", s)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	for _, s := range []string{"0-65535", "80", "1024-1024"} {
+		r, err := ParsePortRange(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", s, err)
+		}
+		if again, _ := ParsePortRange(r.String()); again != r {
+			t.Errorf("%s: '%s' parsed back as '%s'", s, r, again)
+		}
+	}
+
+	for _, s := range []string{"", "x", "80-", "80-22", "65536", "0-99999", "-1"} {
+		if _, err := ParsePortRange(s); err == nil {
+			t.Errorf("%s: expected error on invalid range, got nothing", s)
+		}
+	}
+}
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("bad cidr %s: %v", s, err)
+	}
+	return n
+}
+
+func TestNetNet(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	err := set.Create(noSuchSet, CreateOptionType("hash:net,net"))
+
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	elem := NetNet{First: mustParseCIDR(t, "10.0.0.0/8"), Second: mustParseCIDR(t, "192.168.1.0/24")}
+	ok, err := set.AddElement(noSuchSet, elem)
+	if err != nil {
+		t.Errorf("expected no error on add, got '%v'", err)
+	}
+	if !ok {
+		t.Errorf("expected ok")
+	}
+
+	except := NetNet{First: mustParseCIDR(t, "10.1.0.0/16"), Second: mustParseCIDR(t, "192.168.1.0/24")}
+	if _, err := set.AddElement(noSuchSet, except, EntryOptionNoMatch()); err != nil {
+		t.Errorf("expected no error on add nomatch, got '%v'", err)
+	}
+
+	pair := NetNet{First: mustParseCIDR(t, "10.2.3.4/32"), Second: mustParseCIDR(t, "192.168.1.1/32")}
+	found, err := set.TestElement(noSuchSet, pair)
+	if err != nil {
+		t.Errorf("element %s: unexpected error %v", pair, err)
+	}
+	if !found {
+		t.Errorf("element %s expected in the set %s", pair, noSuchSet)
+	}
+
+	pair = NetNet{First: mustParseCIDR(t, "10.1.3.4/32"), Second: mustParseCIDR(t, "192.168.1.1/32")}
+	found, err = set.TestElement(noSuchSet, pair)
+	if err != nil {
+		t.Errorf("element %s: unexpected error %v", pair, err)
+	}
+	if found {
+		t.Errorf("element %s matches nomatch entry but was found", pair)
+	}
+
+	ok, err = set.DelElement(noSuchSet, elem)
+	if err != nil {
+		t.Errorf("expected no error on del, got '%v'", err)
+	}
+	if !ok {
+		t.Errorf("expected ok")
+	}
+}
+
+func TestNetPortNetV6(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	err := set.Create(noSuchSet, CreateOptionType("hash:net,port,net"), CreateOptionFamily("inet6"))
+
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	elem := NetPortNet{
+		First:  mustParseCIDR(t, "2001:db8::/32"),
+		Port:   ProtoPort{Proto: "udp", Port: 53},
+		Second: mustParseCIDR(t, "fd00::/64"),
+	}
+	ok, err := set.AddElement(noSuchSet, elem)
+	if err != nil {
+		t.Errorf("expected no error on add, got '%v'", err)
+	}
+	if !ok {
+		t.Errorf("expected ok")
+	}
+
+	found, err := set.TestElement(noSuchSet, elem)
+	if err != nil {
+		t.Errorf("element %s: unexpected error %v", elem, err)
+	}
+	if !found {
+		t.Errorf("element %s expected in the set %s", elem, noSuchSet)
+	}
+}
+
+func TestNetNetString(t *testing.T) {
+	elem := NetNet{First: mustParseCIDR(t, "10.0.0.0/8"), Second: mustParseCIDR(t, "192.168.0.0/16")}
+	entry := newEntry(elem, []EntryOption{EntryOptionNoMatch()})
+
+	if s := entry.String(); s != "10.0.0.0/8,192.168.0.0/16 nomatch" {
+		t.Errorf("expected '10.0.0.0/8,192.168.0.0/16 nomatch', was '%s'", s)
+	}
+
+	npn := NetPortNet{First: mustParseCIDR(t, "10.0.0.0/8"), Port: ProtoPort{Proto: "tcp", Port: 22}, Second: mustParseCIDR(t, "fd00::/8")}
+	if s := npn.String(); s != "10.0.0.0/8,tcp:22,fd00::/8" {
+		t.Errorf("expected '10.0.0.0/8,tcp:22,fd00::/8', was '%s'", s)
+	}
+}
+
+func TestNetNetMixedFamily(t *testing.T) {
+	set := New()
+	defer set.Close()
+
+	elem := NetNet{First: mustParseCIDR(t, "10.0.0.0/8"), Second: mustParseCIDR(t, "fd00::/8")}
+	_, err := set.AddElement(noSuchSet, elem)
+
+	if !errors.Is(err, ErrInvalidElement) {
+		t.Errorf("error should be ErrInvalidElement, was %v", err)
+	}
+}
+
+// newThrowawayNetNS returns a file descriptor for a fresh network namespace
+// that lives until the descriptor is closed.
+func newThrowawayNetNS(t *testing.T) int {
+	t.Helper()
+
+	type result struct {
+		fd  int
+		err error
+	}
+	res := make(chan result)
+
+	go func() {
+		// Exits with the thread locked so that the runtime discards it
+		// rather than reusing a thread in the wrong namespace.
+		runtime.LockOSThread()
+
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			res <- result{-1, err}
+			return
+		}
+
+		fd, err := unix.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		res <- result{fd, err}
+	}()
+
+	r := <-res
+	if r.err != nil {
+		t.Skipf("cannot create network namespace: %v", r.err)
+	}
+
+	return r.fd
+}
+
+func TestNetNSIsolation(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	fd := newThrowawayNetNS(t)
+	defer unix.Close(fd)
+
+	nsset, err := NewNetNSFd(fd)
+	if err != nil {
+		t.Fatalf("unexpected error entering namespace: %v", err)
+	}
+	defer nsset.Close()
+
+	_, err = nsset.Info(namedSetV4)
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("host set %s visible in namespace, error was %v", namedSetV4, err)
+	}
+
+	err = nsset.Create(noSuchSet)
+	if err != nil {
+		t.Fatalf("create in namespace failed: %v", err)
+	}
+
+	ok, err := nsset.Add(noSuchSet, net.IPv4(1, 2, 3, 4))
+	if err != nil || !ok {
+		t.Errorf("add in namespace failed: %v", err)
+	}
+
+	set := New()
+	defer set.Close()
+
+	_, err = set.Info(noSuchSet)
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("namespace set %s visible on host, error was %v", noSuchSet, err)
+	}
+}
+
+func TestParseEntry(t *testing.T) {
+	name, entry, ok := parseEntry(`add bl 1.2.3.4 timeout 599 packets 3 bytes 180 comment "from feed x" nomatch`)
+
+	if !ok {
+		t.Fatalf("expected entry to parse")
+	}
+	if name != "bl" {
+		t.Errorf("expected set 'bl', was '%s'", name)
+	}
+	if entry.Element.String() != "1.2.3.4" {
+		t.Errorf("expected element '1.2.3.4', was '%s'", entry.Element)
+	}
+	if entry.Timeout == nil || *entry.Timeout != 599 {
+		t.Errorf("expected timeout 599, was %v", entry.Timeout)
+	}
+	if entry.Packets == nil || *entry.Packets != 3 {
+		t.Errorf("expected 3 packets, was %v", entry.Packets)
+	}
+	if entry.Bytes == nil || *entry.Bytes != 180 {
+		t.Errorf("expected 180 bytes, was %v", entry.Bytes)
+	}
+	if entry.Comment == nil || *entry.Comment != "from feed x" {
+		t.Errorf("expected comment 'from feed x', was %v", entry.Comment)
+	}
+	if !entry.NoMatch {
+		t.Errorf("expected nomatch")
+	}
+
+	expected := `1.2.3.4 timeout 599 packets 3 bytes 180 comment "from feed x" nomatch`
+	if s := entry.String(); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+
+	if _, _, ok := parseEntry("create bl hash:ip family inet"); ok {
+		t.Errorf("create line should not parse as an entry")
+	}
+}
+
+func TestParseCreateLine(t *testing.T) {
+	info, err := ParseCreateLine("create bl hash:ip family inet counters hashsize 1024 comment maxelem 65536 initval 0xd263dc02")
+	if err != nil {
+		t.Fatalf("unexpected error parsing create line: %v", err)
+	}
+	if info.Name != "bl" || info.Type != "hash:ip" || info.Family != "inet" {
+		t.Errorf("expected bl hash:ip inet, was %+v", info)
+	}
+	if !info.Counters || !info.Comment {
+		t.Errorf("expected counters and comment, was %+v", info)
+	}
+	if info.HashSize == nil || *info.HashSize != 1024 {
+		t.Errorf("expected hashsize 1024, was %v", info.HashSize)
+	}
+	if info.MaxElem == nil || *info.MaxElem != 65536 {
+		t.Errorf("expected maxelem 65536, was %v", info.MaxElem)
+	}
+	if info.InitVal == nil || *info.InitVal != 0xd263dc02 {
+		t.Errorf("expected initval 0xd263dc02, was %v", info.InitVal)
+	}
+
+	saved := "create bl hash:ip family inet hashsize 1024 maxelem 65536 bitmask 255.255.255.0"
+	info, err = ParseCreateLine(saved)
+	if err != nil {
+		t.Fatalf("unexpected error parsing '%s': %v", saved, err)
+	}
+	if info.Bitmask == nil || !info.Bitmask.Equal(net.IPv4(255, 255, 255, 0)) {
+		t.Errorf("expected bitmask 255.255.255.0, was %v", info.Bitmask)
+	}
+
+	bad := []string{
+		"",
+		"create",
+		"create bl",
+		"add bl hash:ip",
+		"bl hash:ip family inet",
+		"create -bl hash:ip",
+		"create bl hash:ip family",
+		"create bl hash:ip family inet7",
+		"create bl hash:ip timeout 60 timeout 70",
+		"create bl hash:ip hashsize x",
+		"create bl hash:ip bitmask 24",
+		"create bl hash:ip bitmask",
+		"create bl hash:mac family inet",
+		"create bl bitmap:port range 80-x",
+		"create bl bitmap:port range 0-99999",
+		"create bl bitmap:ip range 10.0.0.1-::1",
+	}
+	for _, line := range bad {
+		if _, err := ParseCreateLine(line); err == nil {
+			t.Errorf("expected error parsing '%s'", line)
+		}
+	}
+}
+
+func ipPtr(ip net.IP) *net.IP {
+	return &ip
+}
+
+func TestCreateLineRoundTrip(t *testing.T) {
+	ipRange := IPRange{First: net.IPv4(10, 0, 0, 0), Last: net.IPv4(10, 0, 255, 255)}
+	portRange := PortRange{First: 1024, Last: 65535}
+	timeout, size, netmask, hashsize, maxelem, bucketsize := 600, 8, 24, 1024, 65536, 12
+	markmask, initval := uint32(0xff00), uint32(0xd263dc02)
+
+	infos := []Info{
+		{Name: "bl", Type: TypeHashIP, Family: FamilyInet6, Timeout: &timeout, Netmask: &netmask, HashSize: &hashsize,
+			MaxElem: &maxelem, BucketSize: &bucketsize, InitVal: &initval, Counters: true, Comment: true, SkbInfo: true, ForceAdd: true},
+		{Name: "bl", Type: TypeHashIPMark, Family: FamilyInet, MarkMask: &markmask},
+		{Name: "bl", Type: TypeHashNet, Family: FamilyInet, Bitmask: ipPtr(net.ParseIP("255.255.255.0"))},
+		{Name: "bl", Type: TypeHashNetNet, Family: FamilyInet6, Bitmask: ipPtr(net.ParseIP("ffff:ffff::"))},
+		{Name: "bl", Type: TypeBitmapIP, IPRange: &ipRange, Netmask: &netmask, Timeout: &timeout},
+		{Name: "bl", Type: TypeBitmapPort, PortRange: &portRange, Counters: true},
+		{Name: "bll", Type: TypeListSet, Size: &size},
+		{Name: "bl", Type: "hash:new", Family: FamilyInet},
+	}
+
+	for _, info := range infos {
+		line := info.CreateLine()
+		parsed, err := ParseCreateLine(line)
+		if err != nil {
+			t.Errorf("unexpected error parsing '%s': %v", line, err)
+			continue
+		}
+		if diffs := DiffInfo(info, parsed); len(diffs) > 0 {
+			t.Errorf("'%s' parsed back with differences %v", line, diffs)
+		}
+		if parsed.HashSize != nil && *parsed.HashSize != hashsize {
+			t.Errorf("'%s': expected hashsize %d, was %d", line, hashsize, *parsed.HashSize)
+		}
+		if again := parsed.CreateLine(); again != line {
+			t.Errorf("expected '%s', was '%s'", line, again)
+		}
+	}
+
+	expected := "create bll list:set size 8"
+	if s := infos[6].CreateLine(); s != expected {
+		t.Errorf("expected '%s', was '%s'", expected, s)
+	}
+}
+
+func TestRename(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	err := set.Rename(namedSetV4, noSuchSet)
+	if err != nil {
+		t.Fatalf("unexpected error renaming set: %v", err)
+	}
+
+	found, err := set.Test(noSuchSet, net.IPv4(1, 2, 3, 4))
+	if err != nil || !found {
+		t.Errorf("expected 1.2.3.4 in renamed set, found %v error %v", found, err)
+	}
+
+	err = set.Rename(noSuchSet, namedSetV6)
+	if !errors.Is(err, ErrSetExists) {
+		t.Errorf("error should be ErrSetExists, was %v", err)
+	}
+
+	err = set.Rename(namedSetV4, "bl3")
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("error should be ErrSetNotFound, was %v", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	err := set.Flush(namedSetV4)
+	if err != nil {
+		t.Fatalf("unexpected error flushing set: %v", err)
+	}
+
+	members, err := set.Members(namedSetV4)
+	if err != nil {
+		t.Fatalf("unexpected error listing members: %v", err)
+	}
+	if len(members) != 0 {
+		t.Errorf("expected no members after flush, was %v", members)
+	}
+
+	err = set.Flush(noSuchSet)
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("error should be ErrSetNotFound, was %v", err)
+	}
+}
+
+func TestSaveRestore(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	saved, err := set.Save(namedSetV4)
+	if err != nil {
+		t.Fatalf("unexpected error saving set: %v", err)
+	}
+	if !strings.HasPrefix(saved, "create "+namedSetV4+" hash:ip") {
+		t.Errorf("expected save output to start with create line, was '%s'", saved)
+	}
+
+	restore := strings.ReplaceAll(saved, " "+namedSetV4+" ", " "+noSuchSet+" ") + "\nCOMMIT\n"
+	err = set.Restore(strings.NewReader(restore))
+	if err != nil {
+		t.Fatalf("unexpected error restoring set: %v", err)
+	}
+
+	found, err := set.Test(noSuchSet, net.IPv4(1, 2, 3, 4))
+	if err != nil || !found {
+		t.Errorf("expected 1.2.3.4 in restored set, found %v error %v", found, err)
+	}
+
+	err = set.Restore(strings.NewReader(restore))
+	if !errors.Is(err, ErrSetExists) {
+		t.Errorf("error should be ErrSetExists, was %v", err)
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "line 1: ") {
+		t.Errorf("error should name the failing line, was %v", err)
+	}
+}
+
+func TestInfoJSON(t *testing.T) {
+	timeout := 600
+	maxelem := 1024
+	r := IPRange{First: net.IPv4(10, 0, 0, 0).To4(), Last: net.IPv4(10, 0, 255, 255).To4()}
+	info := Info{Name: "bl", Type: "bitmap:ip", Timeout: &timeout, IPRange: &r, MaxElem: &maxelem, Counters: true}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+
+	expected := `{"name":"bl","type":"bitmap:ip","timeout":600,"ip_range":"10.0.0.0-10.0.255.255","maxelem":1024,"counters":true}`
+	if string(data) != expected {
+		t.Errorf("expected %s, was %s", expected, data)
+	}
+
+	var decoded Info
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v", err)
+	}
+	if decoded.String() != info.String() {
+		t.Errorf("expected %v after round trip, was %v", info, decoded)
+	}
+}
+
+func TestEntryJSON(t *testing.T) {
+	_, entry, _ := parseEntry(`add bl 10.0.0.0/8 timeout 30 packets 1 bytes 60 comment "feed" nomatch`)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("unexpected error marshalling: %v", err)
+	}
+
+	expected := `{"element":"10.0.0.0/8","timeout":30,"packets":1,"bytes":60,"comment":"feed","nomatch":true}`
+	if string(data) != expected {
+		t.Errorf("expected %s, was %s", expected, data)
+	}
+
+	var decoded Entry
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error unmarshalling: %v", err)
+	}
+	if decoded.String() != entry.String() {
+		t.Errorf("expected %s after round trip, was %s", entry, decoded)
+	}
+
+	data, _ = json.Marshal(Entry{Element: net.IPv4(1, 2, 3, 4)})
+	if string(data) != `{"element":"1.2.3.4"}` {
+		t.Errorf(`expected {"element":"1.2.3.4"}, was %s`, data)
+	}
+}
+
+func TestAddElementsStream(t *testing.T) {
+	var ops []Operation
+	set := New(OptionInterceptor(func(op Operation, next func() error) error {
+		ops = append(ops, op)
+		return next()
+	}))
+	defer set.Close()
+
+	set.SetDryRun(true)
+
+	elems := []Element{net.ParseIP("10.0.0.1"), mustParseCIDR(t, "10.1.0.0/16")}
+	n, err := set.AddElements(noSuchSet, elems, EntryOptionComment("feed x"))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 added, was %d, error %v", n, err)
+	}
+
+	expected := "add bl2 10.0.0.1 comment \"feed x\"\nadd bl2 10.1.0.0/16 comment \"feed x\"\n"
+	if plan := set.Plan(); plan != expected {
+		t.Errorf("expected plan %q, was %q", expected, plan)
+	}
+	if len(ops) != 1 || ops[0].Kind != OpAdd || ops[0].Set != noSuchSet {
+		t.Errorf("expected a single add operation, was %+v", ops)
+	}
+}
+
+func TestAddElementsValidatesFirst(t *testing.T) {
+	set := New(OptionInterceptor(refuseAll(t)))
+	defer set.Close()
+
+	elems := []Element{net.ParseIP("10.0.0.1"), rawElement("-exist"), net.ParseIP("10.0.0.2")}
+	n, err := set.AddElements(noSuchSet, elems)
+	if n != 0 || !errors.Is(err, ErrInvalidElement) {
+		t.Errorf("expected nothing added and ErrInvalidElement, was %d, %v", n, err)
+	}
+}
+
+func TestAddElements(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	var elems []Element
+	for i := 0; i < 1000; i++ {
+		elems = append(elems, net.IPv4(10, 0, byte(i/256), byte(i%256)))
+	}
+	elems = append(elems, net.IPv4(1, 2, 3, 4))
+
+	n, err := set.AddElements(namedSetV4, elems)
+	if err != nil || n != len(elems) {
+		t.Fatalf("expected %d added, was %d, error %v", len(elems), n, err)
+	}
+
+	stats, err := set.Stats(namedSetV4)
+	if err != nil {
+		t.Fatalf("unexpected error getting stats: %v", err)
+	}
+	if stats.Entries != 1001 {
+		t.Errorf("expected 1001 entries, was %d", stats.Entries)
+	}
+
+	_, err = set.AddElements(noSuchSet, elems)
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("error should be ErrSetNotFound, was %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+// The integration tests create and destroy real sets. So that they need no
+// privileges and never touch the sets of the host, TestMain re-runs the
+// test binary as root of a fresh user namespace with its own network
+// namespace, where the tests have CAP_NET_ADMIN over sets nobody else
+// sees.
+//
+// If user namespaces are unavailable the tests run in the calling process
+// and those needing the kernel are skipped. Set IPSET_TEST_HOST=1 to run
+// them against the host's sets instead, which needs root.
+const (
+	sandboxEnv = "IPSET_TEST_SANDBOX"
+	hostEnv    = "IPSET_TEST_HOST"
+)
+
+// kernelUnavailable is why tests needing the kernel are skipped, or nil.
+var kernelUnavailable error
+
+func TestMain(m *testing.M) {
+	switch {
+	case os.Getenv(sandboxEnv) != "":
+		// Inside the sandbox.
+		kernelUnavailable = Preflight()
+	case os.Getenv(hostEnv) != "":
+		kernelUnavailable = Preflight()
+	default:
+		code, err := runInSandbox()
+		if err == nil {
+			os.Exit(code)
+		}
+		kernelUnavailable = fmt.Errorf("no user and network namespace for the tests: %w", err)
+	}
+
+	if kernelUnavailable != nil {
+		fmt.Fprintf(os.Stderr, "skipping tests that need the kernel: %v\n", kernelUnavailable)
+	}
+
+	os.Exit(m.Run())
+}
+
+// runInSandbox runs the test binary again with the same arguments in new
+// user and network namespaces, mapping the current user to root. It
+// returns the exit code of the run, or an error if it could not start.
+func runInSandbox() (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), sandboxEnv+"=1")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// requireKernel skips the test unless it can use ipset in the kernel.
+func requireKernel(t *testing.T) {
+	t.Helper()
+
+	if kernelUnavailable != nil {
+		t.Skipf("needs the kernel: %v", kernelUnavailable)
+	}
+}
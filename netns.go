@@ -0,0 +1,87 @@
+package ipset
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	gopointer "github.com/mattn/go-pointer"
+	"golang.org/x/sys/unix"
+)
+
+// nsThread is a goroutine locked to an OS thread that has entered a
+// network namespace. libipset opens its netlink socket in whatever
+// namespace the calling thread is in, so every call into the library for
+// such a set is funnelled through this thread.
+type nsThread struct {
+	calls chan func()
+}
+
+// NewNetNS is like New but operates on the network namespace at path,
+// e.g. /var/run/netns/foo or /proc/1234/ns/net.
+func NewNetNS(path string, options ...Option) (*IPSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewNetNSFd(int(f.Fd()), options...)
+}
+
+// NewNetNSFd is like NewNetNS but takes an open file descriptor referring
+// to the namespace. The descriptor is only used during the call and may be
+// closed once NewNetNSFd returns.
+func NewNetNSFd(fd int, options ...Option) (*IPSet, error) {
+	ns, err := startNSThread(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &IPSet{ns: ns, interceptors: newConfig(options).interceptors}
+	set.selfptr = gopointer.Save(set)
+	set.do(set.init)
+
+	return set, nil
+}
+
+func startNSThread(fd int) (*nsThread, error) {
+	ns := &nsThread{calls: make(chan func())}
+	errc := make(chan error)
+
+	go func() {
+		// The thread is never unlocked. Once it has switched namespace it
+		// must not be handed back to the scheduler, and exiting the
+		// goroutine while locked makes the runtime terminate the thread.
+		runtime.LockOSThread()
+
+		if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+			errc <- fmt.Errorf("setns: %w", err)
+			return
+		}
+		errc <- nil
+
+		for f := range ns.calls {
+			f()
+		}
+	}()
+
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+
+	return ns, nil
+}
+
+func (ns *nsThread) run(f func()) {
+	done := make(chan struct{})
+	ns.calls <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+}
+
+func (ns *nsThread) stop() {
+	close(ns.calls)
+}
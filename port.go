@@ -0,0 +1,236 @@
+package ipset
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// PortComponent is the port part of an ip,port style element: a port or
+// port range of a protocol, or an ICMP or ICMPv6 type. Port, ProtoPort,
+// ICMP and ICMPv6 implement it.
+type PortComponent interface {
+	Element
+	validatePort(v6 bool) error
+}
+
+// portProtocols are the protocols whose elements carry a port.
+var portProtocols = map[string]bool{
+	"tcp":     true,
+	"udp":     true,
+	"sctp":    true,
+	"udplite": true,
+}
+
+func (p Port) validatePort(v6 bool) error {
+	return validatePortNumber(int(p))
+}
+
+// ProtoPort is a port, or with Last set a port range, of a protocol,
+// rendered as proto:port or proto:port-last. An empty Proto leaves the
+// protocol to libipset, which defaults to tcp. Protocols without ports,
+// such as gre, must have port 0. ICMP types are given as ICMP or ICMPv6
+// instead.
+type ProtoPort struct {
+	Proto string
+	Port  int
+	Last  int
+}
+
+func (p ProtoPort) String() string {
+	port := strconv.Itoa(p.Port)
+	if p.Last != 0 {
+		port = fmt.Sprintf("%d-%d", p.Port, p.Last)
+	}
+	if p.Proto == "" {
+		return port
+	}
+	return fmt.Sprintf("%s:%s", p.Proto, port)
+}
+
+func (p ProtoPort) validatePort(v6 bool) error {
+	switch {
+	case p.Proto == "icmp" || p.Proto == "icmpv6":
+		return fmt.Errorf("%s types are given as ICMP or ICMPv6: %w", p.Proto, ErrInvalidElement)
+	case p.Proto != "" && !portProtocols[p.Proto]:
+		if p.Port != 0 || p.Last != 0 {
+			return fmt.Errorf("protocol %s has no ports, port must be 0: %w", p.Proto, ErrInvalidElement)
+		}
+		return nil
+	}
+
+	if err := validatePortNumber(p.Port); err != nil {
+		return err
+	}
+	if p.Last != 0 {
+		if err := validatePortNumber(p.Last); err != nil {
+			return err
+		}
+		if p.Last < p.Port {
+			return fmt.Errorf("port range %d-%d ends before it starts: %w", p.Port, p.Last, ErrInvalidElement)
+		}
+	}
+	return nil
+}
+
+func validatePortNumber(port int) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("port %d out of range: %w", port, ErrInvalidElement)
+	}
+	return nil
+}
+
+// ICMP is an ICMP type and code for IPv4 elements, rendered as icmp:name
+// if Name is set and as icmp:type/code otherwise.
+type ICMP struct {
+	Name string
+	Type int
+	Code int
+}
+
+func (i ICMP) String() string {
+	return formatICMP("icmp", i.Name, i.Type, i.Code)
+}
+
+func (i ICMP) validatePort(v6 bool) error {
+	if v6 {
+		return fmt.Errorf("icmp with an IPv6 address, use ICMPv6: %w", ErrInvalidElement)
+	}
+	return validateICMP("icmp", i.Name, i.Type, i.Code, icmpTypes)
+}
+
+// ICMPv6 is an ICMPv6 type and code for IPv6 elements, rendered as
+// icmpv6:name if Name is set and as icmpv6:type/code otherwise.
+type ICMPv6 struct {
+	Name string
+	Type int
+	Code int
+}
+
+func (i ICMPv6) String() string {
+	return formatICMP("icmpv6", i.Name, i.Type, i.Code)
+}
+
+func (i ICMPv6) validatePort(v6 bool) error {
+	if !v6 {
+		return fmt.Errorf("icmpv6 with an IPv4 address, use ICMP: %w", ErrInvalidElement)
+	}
+	return validateICMP("icmpv6", i.Name, i.Type, i.Code, icmpv6Types)
+}
+
+func formatICMP(proto string, name string, typ int, code int) string {
+	if name != "" {
+		return fmt.Sprintf("%s:%s", proto, name)
+	}
+	return fmt.Sprintf("%s:%d/%d", proto, typ, code)
+}
+
+func validateICMP(proto string, name string, typ int, code int, names map[string][2]int) error {
+	if name != "" {
+		if _, ok := names[name]; !ok {
+			return fmt.Errorf("unknown %s type '%s': %w", proto, name, ErrInvalidElement)
+		}
+		return nil
+	}
+	if typ < 0 || typ > 255 || code < 0 || code > 255 {
+		return fmt.Errorf("%s type %d/%d out of range: %w", proto, typ, code, ErrInvalidElement)
+	}
+	return nil
+}
+
+// icmpTypes and icmpv6Types are the type names libipset knows, with their
+// type and code.
+var icmpTypes = map[string][2]int{
+	"echo-reply":                 {0, 0},
+	"pong":                       {0, 0},
+	"network-unreachable":        {3, 0},
+	"host-unreachable":           {3, 1},
+	"protocol-unreachable":       {3, 2},
+	"port-unreachable":           {3, 3},
+	"fragmentation-needed":       {3, 4},
+	"source-route-failed":        {3, 5},
+	"network-unknown":            {3, 6},
+	"host-unknown":               {3, 7},
+	"network-prohibited":         {3, 9},
+	"host-prohibited":            {3, 10},
+	"TOS-network-unreachable":    {3, 11},
+	"TOS-host-unreachable":       {3, 12},
+	"communication-prohibited":   {3, 13},
+	"host-precedence-violation":  {3, 14},
+	"precedence-cutoff":          {3, 15},
+	"source-quench":              {4, 0},
+	"network-redirect":           {5, 0},
+	"host-redirect":              {5, 1},
+	"TOS-network-redirect":       {5, 2},
+	"TOS-host-redirect":          {5, 3},
+	"echo-request":               {8, 0},
+	"ping":                       {8, 0},
+	"router-advertisement":       {9, 0},
+	"router-solicitation":        {10, 0},
+	"ttl-zero-during-transit":    {11, 0},
+	"ttl-zero-during-reassembly": {11, 1},
+	"ip-header-bad":              {12, 0},
+	"required-option-missing":    {12, 1},
+	"timestamp-request":          {13, 0},
+	"timestamp-reply":            {14, 0},
+	"address-mask-request":       {17, 0},
+	"address-mask-reply":         {18, 0},
+}
+
+var icmpv6Types = map[string][2]int{
+	"no-route":                   {1, 0},
+	"communication-prohibited":   {1, 1},
+	"address-unreachable":        {1, 3},
+	"port-unreachable":           {1, 4},
+	"packet-too-big":             {2, 0},
+	"ttl-zero-during-transit":    {3, 0},
+	"ttl-zero-during-reassembly": {3, 1},
+	"bad-header":                 {4, 0},
+	"unknown-header-type":        {4, 1},
+	"unknown-option":             {4, 2},
+	"echo-request":               {128, 0},
+	"ping":                       {128, 0},
+	"echo-reply":                 {129, 0},
+	"pong":                       {129, 0},
+	"router-solicitation":        {133, 0},
+	"router-advertisement":       {134, 0},
+	"neighbour-solicitation":     {135, 0},
+	"neighbor-solicitation":      {135, 0},
+	"neighbour-advertisement":    {136, 0},
+	"neighbor-advertisement":     {136, 0},
+	"redirect":                   {137, 0},
+}
+
+// IPPort is the element of a hash:ip,port set.
+type IPPort struct {
+	IP   net.IP
+	Port PortComponent
+}
+
+func (e IPPort) String() string {
+	return fmt.Sprintf("%s,%s", e.IP, e.Port)
+}
+
+func (e IPPort) Validate() error {
+	if e.IP == nil || e.Port == nil {
+		return fmt.Errorf("address and port are required: %w", ErrInvalidElement)
+	}
+	return e.Port.validatePort(e.IP.To4() == nil)
+}
+
+// NetPort is the element of a hash:net,port set.
+type NetPort struct {
+	Net  *net.IPNet
+	Port PortComponent
+}
+
+func (e NetPort) String() string {
+	return fmt.Sprintf("%s,%s", e.Net, e.Port)
+}
+
+func (e NetPort) Validate() error {
+	if e.Net == nil || e.Port == nil {
+		return fmt.Errorf("network and port are required: %w", ErrInvalidElement)
+	}
+	return e.Port.validatePort(e.Net.IP.To4() == nil)
+}
@@ -0,0 +1,111 @@
+package ipset
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPortComponentString(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.1").To4()
+	ip6 := net.ParseIP("2001:db8::1")
+
+	cases := []struct {
+		elem     Element
+		expected string
+	}{
+		{IPPort{IP: ip4, Port: Port(80)}, "192.0.2.1,80"},
+		{IPPort{IP: ip4, Port: ProtoPort{Proto: "tcp", Port: 80, Last: 90}}, "192.0.2.1,tcp:80-90"},
+		{IPPort{IP: ip4, Port: ProtoPort{Proto: "udp", Port: 53}}, "192.0.2.1,udp:53"},
+		{IPPort{IP: ip4, Port: ProtoPort{Proto: "gre"}}, "192.0.2.1,gre:0"},
+		{IPPort{IP: ip4, Port: ICMP{Name: "echo-request"}}, "192.0.2.1,icmp:echo-request"},
+		{IPPort{IP: ip4, Port: ICMP{Type: 3, Code: 4}}, "192.0.2.1,icmp:3/4"},
+		{IPPort{IP: ip6, Port: ICMPv6{Type: 128}}, "2001:db8::1,icmpv6:128/0"},
+		{IPPort{IP: ip6, Port: ICMPv6{Name: "neighbour-solicitation"}}, "2001:db8::1,icmpv6:neighbour-solicitation"},
+		{NetPort{Net: mustParseCIDR(t, "10.0.0.0/8"), Port: ProtoPort{Proto: "sctp", Port: 9}}, "10.0.0.0/8,sctp:9"},
+	}
+
+	for _, c := range cases {
+		if err := validateElement(c.elem); err != nil {
+			t.Errorf("%s: unexpected error %v", c.expected, err)
+		}
+		if s := c.elem.String(); s != c.expected {
+			t.Errorf("expected '%s', was '%s'", c.expected, s)
+		}
+	}
+}
+
+func TestPortComponentValidate(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.1").To4()
+	ip6 := net.ParseIP("2001:db8::1")
+
+	invalid := []Element{
+		IPPort{IP: ip4},
+		IPPort{IP: ip4, Port: Port(65536)},
+		IPPort{IP: ip4, Port: ProtoPort{Proto: "tcp", Port: -1}},
+		IPPort{IP: ip4, Port: ProtoPort{Proto: "tcp", Port: 90, Last: 80}},
+		IPPort{IP: ip4, Port: ProtoPort{Proto: "tcp", Port: 80, Last: 70000}},
+		IPPort{IP: ip4, Port: ProtoPort{Proto: "gre", Port: 1}},
+		IPPort{IP: ip4, Port: ProtoPort{Proto: "icmp", Port: 8}},
+		IPPort{IP: ip4, Port: ICMP{Name: "no-such-type"}},
+		IPPort{IP: ip4, Port: ICMP{Type: 256}},
+		IPPort{IP: ip4, Port: ICMPv6{Type: 128}},
+		IPPort{IP: ip6, Port: ICMP{Name: "echo-request"}},
+		IPPort{IP: ip6, Port: ICMPv6{Name: "source-quench"}},
+		NetPort{Port: Port(80)},
+		NetPort{Net: mustParseCIDR(t, "fd00::/8"), Port: ICMP{Type: 8}},
+		NetPortNet{First: mustParseCIDR(t, "10.0.0.0/8"), Second: mustParseCIDR(t, "10.0.0.0/8")},
+		NetPortNet{First: mustParseCIDR(t, "fd00::/8"), Port: ICMP{Type: 8}, Second: mustParseCIDR(t, "fd00::/8")},
+	}
+
+	set := New()
+	defer set.Close()
+
+	for _, elem := range invalid {
+		if _, err := set.AddElement(noSuchSet, elem); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%v: error should be ErrInvalidElement, was %v", elem, err)
+		}
+	}
+}
+
+func TestIPPortProtocols(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	err := set.Create(noSuchSet, CreateOptionType("hash:ip,port"))
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	ip := net.ParseIP("192.0.2.1").To4()
+	elems := []Element{
+		IPPort{IP: ip, Port: ProtoPort{Proto: "tcp", Port: 80, Last: 81}},
+		IPPort{IP: ip, Port: ProtoPort{Proto: "udp", Port: 53}},
+		IPPort{IP: ip, Port: ICMP{Name: "echo-request"}},
+	}
+	for _, elem := range elems {
+		if _, err := set.AddElement(noSuchSet, elem); err != nil {
+			t.Errorf("element %s: unexpected error %v", elem, err)
+		}
+	}
+
+	tests := []Element{
+		IPPort{IP: ip, Port: ProtoPort{Proto: "tcp", Port: 81}},
+		IPPort{IP: ip, Port: ProtoPort{Proto: "udp", Port: 53}},
+		IPPort{IP: ip, Port: ICMP{Type: 8, Code: 0}},
+	}
+	for _, elem := range tests {
+		found, err := set.TestElement(noSuchSet, elem)
+		if err != nil || !found {
+			t.Errorf("expected %s in set, found %v error %v", elem, found, err)
+		}
+	}
+
+	found, err := set.TestElement(noSuchSet, IPPort{IP: ip, Port: ProtoPort{Proto: "udp", Port: 80}})
+	if err != nil || found {
+		t.Errorf("expected udp:80 not in set, found %v error %v", found, err)
+	}
+}
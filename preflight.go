@@ -0,0 +1,128 @@
+package ipset
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var ErrNoCapability = errors.New("missing CAP_NET_ADMIN")
+var ErrNoModule = errors.New("ip_set kernel module not available")
+var ErrNetlink = errors.New("ipset netlink subsystem unavailable")
+
+// PreflightError is returned by Preflight. It matches one of
+// ErrNoCapability, ErrNoModule or ErrNetlink, and the underlying error if
+// there is one. The message says what to do about it and is meant to be
+// shown as is.
+type PreflightError struct {
+	Kind    error
+	Message string
+	Err     error
+}
+
+func (e *PreflightError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s (%v)", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *PreflightError) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// capNetAdmin is the bit of CAP_NET_ADMIN in a capability set.
+const capNetAdmin = 12
+
+// Preflight checks, in order, that the process has CAP_NET_ADMIN, that the
+// kernel answers ipset requests over netlink and that it supports the
+// protocol of libipset. It returns the first problem found, a
+// *PreflightError or a *VersionError, or nil. Agents can call it at
+// startup to fail early with a clear message instead of on the first
+// command.
+func Preflight() error {
+	return preflight("/proc/self/status", "/sys/module/ip_set", func() (Version, error) {
+		return queryVersion(request)
+	})
+}
+
+// Preflight is like the function Preflight but queries the kernel in the
+// network namespace of set, so that a set made with NewNetNS is checked
+// where its commands will run.
+func (set *IPSet) Preflight() error {
+	return preflight("/proc/self/status", "/sys/module/ip_set", set.Version)
+}
+
+func preflight(statusPath string, modulePath string, version func() (Version, error)) error {
+	status, err := os.ReadFile(statusPath)
+	if err != nil {
+		return &PreflightError{Kind: ErrNoCapability, Message: "can't read the capabilities of the process", Err: err}
+	}
+	caps, err := parseCapEff(string(status))
+	if err != nil {
+		return &PreflightError{Kind: ErrNoCapability, Message: "can't read the capabilities of the process", Err: err}
+	}
+	if caps&(1<<capNetAdmin) == 0 {
+		return &PreflightError{
+			Kind:    ErrNoCapability,
+			Message: "CAP_NET_ADMIN is required: run as root, or grant the capability with setcap cap_net_admin+ep on the binary or AmbientCapabilities=CAP_NET_ADMIN in its systemd unit",
+		}
+	}
+
+	v, err := version()
+	switch {
+	case errors.Is(err, unix.EPERM):
+		return &PreflightError{
+			Kind:    ErrNoCapability,
+			Message: "the kernel refused ipset requests; CAP_NET_ADMIN is needed in the network namespace's user namespace",
+			Err:     err,
+		}
+	case err != nil && !moduleLoaded(modulePath):
+		return &PreflightError{
+			Kind:    ErrNoModule,
+			Message: "the ip_set kernel module is not loaded and could not be loaded on demand: run modprobe ip_set, or list it in /etc/modules-load.d",
+			Err:     err,
+		}
+	case errors.Is(err, ErrVersionProblem):
+		return err
+	case err != nil:
+		return &PreflightError{
+			Kind:    ErrNetlink,
+			Message: "can't query ipset over netlink: check that netfilter netlink (nfnetlink) is available",
+			Err:     err,
+		}
+	}
+
+	if !v.Compatible() {
+		return &VersionError{Message: fmt.Sprintf("libipset speaks ipset protocol %d but the kernel supports %d to %d: install a libipset matching the kernel", v.LibraryProtocol, v.KernelProtocolMin, v.KernelProtocol)}
+	}
+
+	return nil
+}
+
+// parseCapEff returns the effective capabilities from the contents of
+// /proc/<pid>/status.
+func parseCapEff(status string) (uint64, error) {
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		if v, found := strings.CutPrefix(scanner.Text(), "CapEff:"); found {
+			return strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		}
+	}
+	return 0, errors.New("no CapEff line")
+}
+
+// moduleLoaded reports whether the module is loaded or built in, either
+// of which makes it show up in /sys/module.
+func moduleLoaded(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
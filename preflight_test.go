@@ -0,0 +1,113 @@
+package ipset
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const statusRoot = `Name:	agent
+Umask:	0022
+CapInh:	0000000000000000
+CapPrm:	000001ffffffffff
+CapEff:	000001ffffffffff
+CapBnd:	000001ffffffffff
+`
+
+const statusUser = `Name:	agent
+CapInh:	0000000000000000
+CapPrm:	0000000000000000
+CapEff:	0000000000000000
+`
+
+func TestParseCapEff(t *testing.T) {
+	caps, err := parseCapEff(statusRoot)
+	if err != nil || caps != 0x1ffffffffff {
+		t.Errorf("expected 0x1ffffffffff, was %x error %v", caps, err)
+	}
+
+	if _, err := parseCapEff("Name: agent\n"); err == nil {
+		t.Errorf("expected an error without CapEff")
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	user := filepath.Join(dir, "user")
+	os.WriteFile(root, []byte(statusRoot), 0o644)
+	os.WriteFile(user, []byte(statusUser), 0o644)
+	module := dir
+	noModule := filepath.Join(dir, "nosuchmodule")
+
+	ok := func() (Version, error) {
+		return Version{LibraryProtocol: 7, KernelProtocol: 7, KernelProtocolMin: 6}, nil
+	}
+	old := func() (Version, error) {
+		return Version{LibraryProtocol: 7, KernelProtocol: 6, KernelProtocolMin: 6}, nil
+	}
+	failing := func(err error) func() (Version, error) {
+		return func() (Version, error) { return Version{}, err }
+	}
+
+	cases := []struct {
+		name     string
+		status   string
+		module   string
+		version  func() (Version, error)
+		expected error
+	}{
+		{"ok", root, module, ok, nil},
+		{"unprivileged", user, module, ok, ErrNoCapability},
+		{"no status", filepath.Join(dir, "nope"), module, ok, ErrNoCapability},
+		{"refused", root, module, failing(unix.EPERM), ErrNoCapability},
+		{"no module", root, noModule, failing(unix.EINVAL), ErrNoModule},
+		{"netlink", root, module, failing(unix.EPROTONOSUPPORT), ErrNetlink},
+		{"protocol", root, module, failing(&VersionError{Message: "x"}), ErrVersionProblem},
+		{"old kernel", root, module, old, ErrVersionProblem},
+	}
+
+	for _, c := range cases {
+		err := preflight(c.status, c.module, c.version)
+		if c.expected == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, c.expected) {
+			t.Errorf("%s: error should be %v, was %v", c.name, c.expected, err)
+		}
+	}
+
+	err := preflight(root, module, failing(unix.EPERM))
+	if !errors.Is(err, unix.EPERM) {
+		t.Errorf("error should wrap EPERM, was %v", err)
+	}
+	var perr *PreflightError
+	if !errors.As(err, &perr) || perr.Message == "" {
+		t.Errorf("error should be a PreflightError with a message, was %v", err)
+	}
+}
+
+func TestPreflightHost(t *testing.T) {
+	requireKernel(t)
+
+	if err := Preflight(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestPreflightSet(t *testing.T) {
+	requireKernel(t)
+
+	set := New()
+	defer set.Close()
+
+	if err := set.Preflight(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,265 @@
+package ipset
+
+/*
+#include <libipset/ipset.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// libraryProtocol is the ipset netlink protocol version libipset speaks.
+const libraryProtocol = C.IPSET_PROTOCOL
+
+// Version reports the ipset protocol versions of libipset and the kernel.
+// libipset has no run-time release number; its protocol version is what
+// decides compatibility with the kernel.
+type Version struct {
+	LibraryProtocol   int `json:"library_protocol"`
+	KernelProtocol    int `json:"kernel_protocol"`
+	KernelProtocolMin int `json:"kernel_protocol_min"`
+}
+
+// Compatible reports whether the kernel supports the protocol of libipset.
+func (v Version) Compatible() bool {
+	return v.KernelProtocolMin <= v.LibraryProtocol && v.LibraryProtocol <= v.KernelProtocol
+}
+
+// TypeRevisions is the range of revisions of a set type the kernel
+// supports for a family.
+type TypeRevisions struct {
+	Type   SetType `json:"type"`
+	Family Family  `json:"family"`
+	Min    int     `json:"min"`
+	Max    int     `json:"max"`
+}
+
+// Netlink constants from linux/netfilter/ipset/ip_set.h and
+// linux/netfilter/nfnetlink.h.
+const (
+	nfnlSubsysIPSet = 6
+
+	ipsetCmdProtocol = 1
+	ipsetCmdType     = 13
+
+	ipsetAttrProtocol    = 1
+	ipsetAttrTypeName    = 3
+	ipsetAttrRevision    = 4
+	ipsetAttrFamily      = 5
+	ipsetAttrRevisionMin = 10
+	ipsetAttrProtocolMin = 10
+
+	ipsetErrProtocol = 4097
+	ipsetErrFindType = 4098
+
+	nfprotoIPv4 = 2
+	nfprotoIPv6 = 10
+)
+
+// Version asks the kernel which protocol versions it supports.
+func (set *IPSet) Version() (Version, error) {
+	return queryVersion(set.request)
+}
+
+func queryVersion(request func(cmd uint16, nfproto byte, attrs ...[]byte) (map[uint16][]byte, error)) (Version, error) {
+	v := Version{LibraryProtocol: libraryProtocol}
+
+	attrs, err := request(ipsetCmdProtocol, nfprotoIPv4, netlinkAttr(ipsetAttrProtocol, []byte{libraryProtocol}))
+	if err != nil {
+		return v, err
+	}
+
+	v.KernelProtocol = int(attrByte(attrs, ipsetAttrProtocol))
+	v.KernelProtocolMin = v.KernelProtocol
+	if _, ok := attrs[ipsetAttrProtocolMin]; ok {
+		v.KernelProtocolMin = int(attrByte(attrs, ipsetAttrProtocolMin))
+	}
+
+	return v, nil
+}
+
+// TypeRevisions asks the kernel which revisions of the set type it
+// supports for family, inet or inet6. A type the kernel does not know is a
+// VersionError.
+func (set *IPSet) TypeRevisions(typ SetType, family Family) (TypeRevisions, error) {
+	nfproto := byte(nfprotoIPv4)
+	if family == FamilyInet6 {
+		nfproto = nfprotoIPv6
+	}
+
+	attrs, err := set.request(ipsetCmdType, nfproto,
+		netlinkAttr(ipsetAttrProtocol, []byte{libraryProtocol}),
+		netlinkAttr(ipsetAttrTypeName, append([]byte(typ), 0)),
+		netlinkAttr(ipsetAttrFamily, []byte{nfproto}),
+	)
+	if errors.Is(err, unix.EEXIST) || errors.Is(err, unix.Errno(ipsetErrFindType)) {
+		return TypeRevisions{}, &VersionError{Message: fmt.Sprintf("kernel does not support set type %s for %s", typ, family)}
+	}
+	if err != nil {
+		return TypeRevisions{}, err
+	}
+
+	return TypeRevisions{
+		Type:   typ,
+		Family: family,
+		Min:    int(attrByte(attrs, ipsetAttrRevisionMin)),
+		Max:    int(attrByte(attrs, ipsetAttrRevision)),
+	}, nil
+}
+
+// SupportedTypes probes every set type of current kernels, in each family
+// it holds, and returns the ones the kernel supports, one per type and
+// family. Types holding no particular family are probed as inet.
+func (set *IPSet) SupportedTypes() ([]TypeRevisions, error) {
+	var types []TypeRevisions
+
+	for _, t := range setTypes {
+		families := t.Families
+		if len(families) == 0 {
+			families = []Family{FamilyInet}
+		}
+
+		for _, f := range families {
+			revs, err := set.TypeRevisions(t.Type, f)
+			if errors.Is(err, ErrVersionProblem) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			types = append(types, revs)
+		}
+	}
+
+	return types, nil
+}
+
+// request sends an ipset command straight over netlink, in the set's
+// network namespace, and returns the attributes of the reply. libipset
+// has no API for these queries.
+func (set *IPSet) request(cmd uint16, nfproto byte, attrs ...[]byte) (map[uint16][]byte, error) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+
+	var reply map[uint16][]byte
+	var err error
+	set.do(func() {
+		reply, err = request(cmd, nfproto, attrs...)
+	})
+
+	return reply, err
+}
+
+// request is like IPSet.request but in the namespace of the calling
+// thread.
+func request(cmd uint16, nfproto byte, attrs ...[]byte) (map[uint16][]byte, error) {
+	reply, err := netlinkRequest(cmd, nfproto, attrs)
+	if errors.Is(err, unix.Errno(ipsetErrProtocol)) {
+		return nil, &VersionError{Message: fmt.Sprintf("kernel does not support ipset protocol %d", libraryProtocol)}
+	}
+	return reply, err
+}
+
+func netlinkRequest(cmd uint16, nfproto byte, attrs [][]byte) (map[uint16][]byte, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_NETFILTER)
+	if err != nil {
+		return nil, fmt.Errorf("netlink socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("netlink bind: %w", err)
+	}
+
+	// nlmsghdr, nfgenmsg and the attributes.
+	msg := make([]byte, unix.NLMSG_HDRLEN+4)
+	binary.NativeEndian.PutUint16(msg[4:], nfnlSubsysIPSet<<8|cmd)
+	binary.NativeEndian.PutUint16(msg[6:], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:], 1)
+	msg[unix.NLMSG_HDRLEN] = nfproto
+	msg[unix.NLMSG_HDRLEN+1] = unix.NFNETLINK_V0
+	for _, a := range attrs {
+		msg = append(msg, a...)
+	}
+	binary.NativeEndian.PutUint32(msg[0:], uint32(len(msg)))
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, fmt.Errorf("netlink send: %w", err)
+	}
+
+	var reply map[uint16][]byte
+	buf := make([]byte, unix.Getpagesize())
+
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive: %w", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return nil, fmt.Errorf("netlink receive: %w", err)
+		}
+
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case unix.NLMSG_ERROR:
+				if len(m.Data) < 4 {
+					return nil, errors.New("netlink receive: short error message")
+				}
+				if errno := -int32(binary.NativeEndian.Uint32(m.Data)); errno != 0 {
+					return nil, unix.Errno(errno)
+				}
+				// The acknowledgement ends the reply.
+				return reply, nil
+			case unix.NLMSG_DONE:
+				return reply, nil
+			default:
+				if len(m.Data) >= 4 {
+					reply = parseNetlinkAttrs(m.Data[4:])
+				}
+			}
+		}
+	}
+}
+
+func netlinkAttr(typ uint16, data []byte) []byte {
+	n := unix.SizeofNlAttr + len(data)
+	a := make([]byte, (n+unix.NLA_ALIGNTO-1) & ^(unix.NLA_ALIGNTO-1))
+	binary.NativeEndian.PutUint16(a[0:], uint16(n))
+	binary.NativeEndian.PutUint16(a[2:], typ)
+	copy(a[unix.SizeofNlAttr:], data)
+	return a
+}
+
+func parseNetlinkAttrs(b []byte) map[uint16][]byte {
+	attrs := map[uint16][]byte{}
+	for len(b) >= unix.SizeofNlAttr {
+		n := int(binary.NativeEndian.Uint16(b[0:]))
+		typ := binary.NativeEndian.Uint16(b[2:]) &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+		if n < unix.SizeofNlAttr || n > len(b) {
+			break
+		}
+		attrs[typ] = append([]byte(nil), b[unix.SizeofNlAttr:n]...)
+
+		aligned := (n + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return attrs
+}
+
+func attrByte(attrs map[uint16][]byte, typ uint16) byte {
+	if a := attrs[typ]; len(a) > 0 {
+		return a[0]
+	}
+	return 0
+}
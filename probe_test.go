@@ -0,0 +1,118 @@
+package ipset
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNetlinkAttrs(t *testing.T) {
+	var b []byte
+	b = append(b, netlinkAttr(ipsetAttrProtocol, []byte{7})...)
+	b = append(b, netlinkAttr(ipsetAttrTypeName, append([]byte("hash:ip"), 0))...)
+	b = append(b, netlinkAttr(ipsetAttrRevisionMin, []byte{2})...)
+
+	if len(b)%4 != 0 {
+		t.Errorf("attributes not aligned, length %d", len(b))
+	}
+
+	attrs := parseNetlinkAttrs(b)
+	if attrByte(attrs, ipsetAttrProtocol) != 7 || attrByte(attrs, ipsetAttrRevisionMin) != 2 {
+		t.Errorf("unexpected attributes %v", attrs)
+	}
+	if s := string(attrs[ipsetAttrTypeName]); s != "hash:ip\x00" {
+		t.Errorf("expected type name 'hash:ip', was %q", s)
+	}
+
+	if attrs := parseNetlinkAttrs([]byte{0xff, 0, 1}); len(attrs) != 0 {
+		t.Errorf("expected no attributes from a short buffer, was %v", attrs)
+	}
+}
+
+func TestVersionCompatible(t *testing.T) {
+	cases := []struct {
+		v        Version
+		expected bool
+	}{
+		{Version{LibraryProtocol: 7, KernelProtocol: 7, KernelProtocolMin: 6}, true},
+		{Version{LibraryProtocol: 6, KernelProtocol: 7, KernelProtocolMin: 6}, true},
+		{Version{LibraryProtocol: 7, KernelProtocol: 6, KernelProtocolMin: 6}, false},
+	}
+
+	for _, c := range cases {
+		if c.v.Compatible() != c.expected {
+			t.Errorf("%+v: expected compatible %v", c.v, c.expected)
+		}
+	}
+}
+
+func TestVersionProblemError(t *testing.T) {
+	err := transformCmdError(&cmdError{
+		Level:   errorLevelError,
+		Message: "Kernel and userspace incompatible: settype hash:ip with revision 7 not supported by userspace.",
+	})
+
+	if !errors.Is(err, ErrVersionProblem) {
+		t.Errorf("error should be ErrVersionProblem, was %v", err)
+	}
+	var verr *VersionError
+	if !errors.As(err, &verr) {
+		t.Errorf("error should be a VersionError, was %v", err)
+	}
+
+	err = transformCmdError(&cmdError{Level: errorLevelError, Message: "The set with the given name does not exist"})
+	if errors.Is(err, ErrVersionProblem) {
+		t.Errorf("error should not be ErrVersionProblem, was %v", err)
+	}
+}
+
+func TestKernelVersion(t *testing.T) {
+	requireKernel(t)
+
+	set := New()
+	defer set.Close()
+
+	v, err := set.Version()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if v.LibraryProtocol == 0 || v.KernelProtocol == 0 {
+		t.Errorf("expected protocol versions, was %+v", v)
+	}
+	if !v.Compatible() {
+		t.Errorf("expected libipset and kernel compatible, was %+v", v)
+	}
+}
+
+func TestTypeRevisions(t *testing.T) {
+	requireKernel(t)
+
+	set := New()
+	defer set.Close()
+
+	revs, err := set.TypeRevisions("hash:ip", "inet6")
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if revs.Max == 0 || revs.Min > revs.Max {
+		t.Errorf("unexpected revisions %+v", revs)
+	}
+
+	_, err = set.TypeRevisions("hash:nosuchtype", "inet")
+	if !errors.Is(err, ErrVersionProblem) {
+		t.Errorf("error should be ErrVersionProblem, was %v", err)
+	}
+
+	types, err := set.SupportedTypes()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	found := map[Family]bool{}
+	for _, typ := range types {
+		if typ.Type == TypeHashIP {
+			found[typ.Family] = true
+		}
+	}
+	if !found[FamilyInet] || !found[FamilyInet6] {
+		t.Errorf("expected hash:ip of both families among supported types, was %+v", types)
+	}
+}
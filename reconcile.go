@@ -0,0 +1,290 @@
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strings"
+)
+
+// DesiredSet is the wanted definition and contents of a single set.
+//
+// Members are compared by element alone and added without options, so
+// their timeouts, comments and nomatch flags are neither set nor checked.
+// The members of a list:set are kept in the order given; a SetMember
+// placed with Before or After, and an Entry used as a member, are rejected
+// as ErrInvalidElement.
+type DesiredSet struct {
+	Info    Info
+	Members []Element
+}
+
+// SetChange records what Reconcile did to a single set.
+type SetChange struct {
+	Name     string
+	Created  bool
+	Replaced bool
+	Added    []string
+	Deleted  []string
+}
+
+func (c SetChange) Changed() bool {
+	return c.Created || c.Replaced || len(c.Added) > 0 || len(c.Deleted) > 0
+}
+
+type ReconcileReport struct {
+	Changes []SetChange
+}
+
+func (r ReconcileReport) Changed() bool {
+	return len(r.Changes) > 0
+}
+
+// Reconcile brings the kernel in line with the desired sets. Missing sets
+// are created, sets whose definition differs are replaced, and the members
+// of the rest are added and deleted as needed. Sets not mentioned in
+// desired are left alone.
+//
+// A replaced set is built under a temporary name and swapped in when the
+// type and family are unchanged, so that rules referencing it keep working.
+// Otherwise it is destroyed and created again, which fails if it is in use.
+func (set *IPSet) Reconcile(desired []DesiredSet) (ReconcileReport, error) {
+	for _, d := range desired {
+		if err := checkMembers(d.Members); err != nil {
+			return ReconcileReport{}, fmt.Errorf("reconcile %s: %w", d.Info.Name, err)
+		}
+	}
+
+	ordered := make([]DesiredSet, len(desired))
+	copy(ordered, desired)
+
+	// list:set members must exist before they can be added.
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !isListType(ordered[i].Info.Type) && isListType(ordered[j].Info.Type)
+	})
+
+	var report ReconcileReport
+
+	for _, d := range ordered {
+		change, err := set.reconcileSet(d)
+		if change.Changed() {
+			report.Changes = append(report.Changes, change)
+		}
+		if err != nil {
+			return report, fmt.Errorf("reconcile %s: %w", d.Info.Name, err)
+		}
+	}
+
+	return report, nil
+}
+
+func (set *IPSet) reconcileSet(d DesiredSet) (SetChange, error) {
+	want := withDefaults(d.Info)
+	change := SetChange{Name: want.Name}
+
+	have, err := set.Info(want.Name)
+	if errors.Is(err, ErrSetNotFound) {
+		if err := set.create(want); err != nil {
+			return change, err
+		}
+		change.Created = true
+		change.Added, err = set.addAll(want.Name, d.Members)
+		return change, err
+	} else if err != nil {
+		return change, err
+	}
+
+	if RequiresRecreate(DiffInfo(want, have)) {
+		change.Replaced = true
+		change.Added, err = set.replace(want, have, d.Members)
+		return change, err
+	}
+
+	actual, err := set.Members(want.Name)
+	if err != nil {
+		return change, err
+	}
+
+	wanted := make([]string, len(d.Members))
+	byKey := make(map[string]Element, len(d.Members))
+	for i, m := range d.Members {
+		wanted[i] = memberKey(m)
+		byKey[wanted[i]] = m
+	}
+
+	add, del := diffMembers(wanted, actual, isListType(want.Type))
+
+	for _, m := range del {
+		if _, err := set.DelElement(want.Name, rawElement(m)); err != nil {
+			return change, err
+		}
+		change.Deleted = append(change.Deleted, m)
+	}
+
+	for _, k := range add {
+		if _, err := set.AddElement(want.Name, byKey[k]); err != nil {
+			return change, err
+		}
+		change.Added = append(change.Added, k)
+	}
+
+	return change, nil
+}
+
+func (set *IPSet) addAll(name string, members []Element) ([]string, error) {
+	var added []string
+	for _, m := range members {
+		if _, err := set.AddElement(name, m); err != nil {
+			return added, err
+		}
+		added = append(added, memberKey(m))
+	}
+	return added, nil
+}
+
+func (set *IPSet) replace(want Info, have Info, members []Element) ([]string, error) {
+	if want.Type != have.Type || want.Family != have.Family {
+		if err := set.Destroy(want.Name); err != nil {
+			return nil, err
+		}
+		if err := set.create(want); err != nil {
+			return nil, err
+		}
+		return set.addAll(want.Name, members)
+	}
+
+	// The temporary set is only ever destroyed once created here; a set
+	// already using its name is left alone and fails with ErrSetExists.
+	tmp := want
+	tmp.Name = tempSetName(want.Name)
+
+	if err := set.create(tmp); err != nil {
+		return nil, err
+	}
+
+	added, err := set.addAll(tmp.Name, members)
+	if err == nil {
+		err = set.Swap(tmp.Name, want.Name)
+	}
+	if derr := set.Destroy(tmp.Name); err == nil {
+		err = derr
+	}
+
+	return added, err
+}
+
+// checkMembers rejects members that can't be compared by element alone.
+func checkMembers(members []Element) error {
+	for _, m := range members {
+		switch e := m.(type) {
+		case SetMember:
+			if e.Before != "" || e.After != "" {
+				return fmt.Errorf("'%s': list:set members are ordered as given, not by before or after: %w", e, ErrInvalidElement)
+			}
+		case Entry:
+			return fmt.Errorf("'%s': members are added without options: %w", e, ErrInvalidElement)
+		}
+	}
+	return nil
+}
+
+// diffMembers returns the keys in want missing from have and the members
+// of have not in want. When ordered is set, as for list:set, members that
+// are present but out of place are deleted and added again at the end so
+// that the resulting order matches want.
+func diffMembers(want []string, have []string, ordered bool) (add []string, del []string) {
+	wanted := make(map[string]bool, len(want))
+	for _, k := range want {
+		wanted[k] = true
+	}
+
+	present := make(map[string]bool, len(have))
+	var kept []string
+	for _, m := range have {
+		k := normalizeElement(m)
+		if wanted[k] && !present[k] {
+			present[k] = true
+			kept = append(kept, m)
+		} else {
+			del = append(del, m)
+		}
+	}
+
+	if !ordered {
+		for _, k := range want {
+			if !present[k] {
+				add = append(add, k)
+				present[k] = true
+			}
+		}
+		return add, del
+	}
+
+	i := 0
+	for i < len(kept) && i < len(want) && normalizeElement(kept[i]) == want[i] {
+		i++
+	}
+	del = append(del, kept[i:]...)
+
+	return want[i:], del
+}
+
+// memberKey is the form of a desired member that is compared against the
+// members listed by the kernel.
+func memberKey(elem Element) string {
+	if m, ok := elem.(SetMember); ok {
+		return m.Name
+	}
+	return normalizeElement(elem.String())
+}
+
+// normalizeElement rewrites the addresses and prefixes of an element the
+// way the kernel lists them: networks are masked, host prefixes are
+// dropped and IPv6 addresses are compressed.
+func normalizeElement(s string) string {
+	parts := strings.Split(s, ",")
+	for i, p := range parts {
+		if ip, n, err := net.ParseCIDR(p); err == nil {
+			if ones, bits := n.Mask.Size(); ones == bits {
+				parts[i] = ip.String()
+			} else {
+				parts[i] = n.String()
+			}
+		} else if ip := net.ParseIP(p); ip != nil {
+			parts[i] = ip.String()
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func withDefaults(info Info) Info {
+	if info.Type == "" {
+		info.Type = TypeHashIP
+	}
+	if info.Family == "" && typeHasFamily(info.Type) {
+		info.Family = FamilyInet
+	}
+	// Types without a family parameter ignore a valid one when created and
+	// are listed without it.
+	if !typeHasFamily(info.Type) && info.Validate() == nil {
+		info.Family = ""
+	}
+	return info
+}
+
+func isListType(typ SetType) bool {
+	return strings.HasPrefix(string(typ), "list:")
+}
+
+// tempSetName returns a name for a temporary copy of the named set, made
+// unique by a random suffix so that long names sharing a prefix don't
+// collide.
+func tempSetName(name string) string {
+	suffix := fmt.Sprintf("-%08x", rand.Uint32())
+	if len(name) > maxSetNameLen-len(suffix) {
+		name = name[:maxSetNameLen-len(suffix)]
+	}
+	return name + suffix
+}
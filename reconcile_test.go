@@ -0,0 +1,182 @@
+package ipset
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	desired := []DesiredSet{{
+		Info:    Info{Name: noSuchSet},
+		Members: []Element{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)},
+	}}
+
+	report, err := set.Reconcile(desired)
+	if err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+	if len(report.Changes) != 1 || !report.Changes[0].Created || len(report.Changes[0].Added) != 2 {
+		t.Errorf("expected set created with 2 members, was %+v", report.Changes)
+	}
+
+	report, err = set.Reconcile(desired)
+	if err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+	if report.Changed() {
+		t.Errorf("expected no changes, was %+v", report.Changes)
+	}
+
+	desired[0].Members = []Element{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)}
+	report, err = set.Reconcile(desired)
+	if err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+	if len(report.Changes) != 1 {
+		t.Fatalf("expected one change, was %+v", report.Changes)
+	}
+	if c := report.Changes[0]; strings.Join(c.Added, ",") != "10.0.0.3" || strings.Join(c.Deleted, ",") != "10.0.0.1" {
+		t.Errorf("expected 10.0.0.3 added and 10.0.0.1 deleted, was %+v", c)
+	}
+
+	timeout := 300
+	desired[0].Info.Timeout = &timeout
+	report, err = set.Reconcile(desired)
+	if err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+	if len(report.Changes) != 1 || !report.Changes[0].Replaced {
+		t.Errorf("expected set replaced, was %+v", report.Changes)
+	}
+
+	info, err := set.Info(noSuchSet)
+	if err != nil {
+		t.Fatalf("expected set '%s', got error: %v", noSuchSet, err)
+	}
+	if info.Timeout == nil || *info.Timeout != timeout {
+		t.Errorf("expected timeout %d, was %v", timeout, info.Timeout)
+	}
+
+	found, err := set.Test(noSuchSet, net.IPv4(10, 0, 0, 3))
+	if err != nil || !found {
+		t.Errorf("expected 10.0.0.3 in replaced set, found %v error %v", found, err)
+	}
+}
+
+// TestReconcileNoFamily checks that a family given for a type taking none
+// doesn't make every run recreate the set.
+func TestReconcileNoFamily(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	desired := []DesiredSet{{
+		Info:    Info{Name: noSuchSet, Type: TypeBitmapPort, Family: FamilyInet, PortRange: &PortRange{First: 0, Last: 1024}},
+		Members: []Element{Port(80)},
+	}}
+
+	if _, err := set.Reconcile(desired); err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+
+	report, err := set.Reconcile(desired)
+	if err != nil {
+		t.Fatalf("unexpected error reconciling: %v", err)
+	}
+	if report.Changed() {
+		t.Errorf("expected no changes, was %+v", report.Changes)
+	}
+}
+
+func TestReconcileRejectsUnsupportedMembers(t *testing.T) {
+	set := New(OptionInterceptor(refuseAll(t)))
+	defer set.Close()
+
+	members := [][]Element{
+		{SetMember{Name: namedSetV4, Before: namedSetV6}},
+		{SetMember{Name: namedSetV4}, SetMember{Name: namedSetV6, After: namedSetV4}},
+		{Entry{Element: net.IPv4(10, 0, 0, 1), NoMatch: true}},
+	}
+
+	for _, m := range members {
+		desired := []DesiredSet{
+			{Info: Info{Name: noSuchSet}},
+			{Info: Info{Name: listSet, Type: TypeListSet}, Members: m},
+		}
+		if _, err := set.Reconcile(desired); !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%v: error should be ErrInvalidElement, was %v", m, err)
+		}
+	}
+}
+
+func TestDiffMembers(t *testing.T) {
+	cases := []struct {
+		want    []string
+		have    []string
+		ordered bool
+		add     string
+		del     string
+	}{
+		{[]string{"a", "b"}, []string{"a", "b"}, false, "", ""},
+		{[]string{"a", "b"}, []string{"b", "c"}, false, "a", "c"},
+		{[]string{"a", "b"}, []string{"b", "a"}, false, "", ""},
+		{[]string{"a", "b"}, []string{"b", "a"}, true, "a,b", "b,a"},
+		{[]string{"a", "b", "c"}, []string{"a", "c"}, true, "b,c", "c"},
+		{[]string{"a", "b"}, []string{"a", "x", "b"}, true, "", "x"},
+		{[]string{"10.0.0.0/8"}, []string{"10.0.0.0/8"}, false, "", ""},
+	}
+
+	for _, c := range cases {
+		add, del := diffMembers(c.want, c.have, c.ordered)
+		if strings.Join(add, ",") != c.add || strings.Join(del, ",") != c.del {
+			t.Errorf("want %v have %v ordered %v: expected add '%s' del '%s', was add %v del %v", c.want, c.have, c.ordered, c.add, c.del, add, del)
+		}
+	}
+}
+
+func TestNormalizeElement(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{"1.2.3.4/32", "1.2.3.4"},
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{"2001:0db8:0000::1/128", "2001:db8::1"},
+		{"10.0.0.0/8,tcp:80,192.168.0.0/16", "10.0.0.0/8,tcp:80,192.168.0.0/16"},
+		{"bl4", "bl4"},
+	}
+
+	for _, c := range cases {
+		if s := normalizeElement(c.input); s != c.expected {
+			t.Errorf("element %s: expected '%s', was '%s'", c.input, c.expected, s)
+		}
+	}
+}
+
+func TestTempSetName(t *testing.T) {
+	long := strings.Repeat("x", maxSetNameLen)
+
+	a, b := tempSetName(long+"a"), tempSetName(long+"b")
+	if a == b {
+		t.Errorf("expected distinct temporary names, both were '%s'", a)
+	}
+	for _, name := range []string{a, b, tempSetName("bl")} {
+		if err := ValidateSetName(name); err != nil {
+			t.Errorf("'%s': %v", name, err)
+		}
+	}
+	if tmp := tempSetName("bl"); !strings.HasPrefix(tmp, "bl-") || tmp == tempSetName("bl") {
+		t.Errorf("expected a unique name starting with 'bl-', was '%s'", tmp)
+	}
+}
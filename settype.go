@@ -0,0 +1,285 @@
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+var ErrInvalidSetType = errors.New("invalid set type")
+var ErrInvalidFamily = errors.New("invalid family")
+var ErrUnsupportedOption = errors.New("unsupported create option")
+
+// SetType is the type of a set, such as hash:ip.
+type SetType string
+
+const (
+	TypeHashIP         SetType = "hash:ip"
+	TypeHashMAC        SetType = "hash:mac"
+	TypeHashIPMAC      SetType = "hash:ip,mac"
+	TypeHashNet        SetType = "hash:net"
+	TypeHashNetNet     SetType = "hash:net,net"
+	TypeHashIPPort     SetType = "hash:ip,port"
+	TypeHashNetPort    SetType = "hash:net,port"
+	TypeHashIPPortIP   SetType = "hash:ip,port,ip"
+	TypeHashIPPortNet  SetType = "hash:ip,port,net"
+	TypeHashIPMark     SetType = "hash:ip,mark"
+	TypeHashNetPortNet SetType = "hash:net,port,net"
+	TypeHashNetIface   SetType = "hash:net,iface"
+	TypeBitmapIP       SetType = "bitmap:ip"
+	TypeBitmapIPMAC    SetType = "bitmap:ip,mac"
+	TypeBitmapPort     SetType = "bitmap:port"
+	TypeListSet        SetType = "list:set"
+)
+
+// Family is the address family of a set.
+type Family string
+
+const (
+	FamilyInet  Family = "inet"
+	FamilyInet6 Family = "inet6"
+)
+
+// Validate checks that f is inet or inet6.
+func (f Family) Validate() error {
+	if f != FamilyInet && f != FamilyInet6 {
+		return fmt.Errorf("'%s' is not inet or inet6: %w", f, ErrInvalidFamily)
+	}
+	return nil
+}
+
+// Dimension is the kind of one comma separated component of an element.
+type Dimension string
+
+const (
+	DimensionIP    Dimension = "ip"
+	DimensionNet   Dimension = "net"
+	DimensionPort  Dimension = "port"
+	DimensionMAC   Dimension = "mac"
+	DimensionMark  Dimension = "mark"
+	DimensionIface Dimension = "iface"
+	DimensionSet   Dimension = "set"
+)
+
+// TypeCapabilities describes a set type: the components of its elements,
+// the families of addresses it holds and the create parameters it takes,
+// by their names on the command line. Required parameters must be given.
+type TypeCapabilities struct {
+	Type       SetType     `json:"type"`
+	Dimensions []Dimension `json:"dimensions"`
+	Families   []Family    `json:"families,omitempty"`
+	Options    []string    `json:"options"`
+	Required   []string    `json:"required,omitempty"`
+}
+
+var (
+	bothFamilies = []Family{FamilyInet, FamilyInet6}
+	hashOptions  = []string{"family", "hashsize", "maxelem", "bucketsize", "initval", "timeout", "counters", "comment", "skbinfo", "forceadd"}
+	extOptions   = []string{"timeout", "counters", "comment", "skbinfo"}
+)
+
+// setTypes are the set types of current kernels.
+var setTypes = []TypeCapabilities{
+	{TypeHashIP, []Dimension{DimensionIP}, bothFamilies, append([]string{"netmask", "bitmask"}, hashOptions...), nil},
+	{TypeHashMAC, []Dimension{DimensionMAC}, nil, hashOptions[1:], nil},
+	{TypeHashIPMAC, []Dimension{DimensionIP, DimensionMAC}, bothFamilies, hashOptions, nil},
+	{TypeHashNet, []Dimension{DimensionNet}, bothFamilies, append([]string{"bitmask"}, hashOptions...), nil},
+	{TypeHashNetNet, []Dimension{DimensionNet, DimensionNet}, bothFamilies, append([]string{"bitmask"}, hashOptions...), nil},
+	{TypeHashIPPort, []Dimension{DimensionIP, DimensionPort}, bothFamilies, hashOptions, nil},
+	{TypeHashNetPort, []Dimension{DimensionNet, DimensionPort}, bothFamilies, hashOptions, nil},
+	{TypeHashIPPortIP, []Dimension{DimensionIP, DimensionPort, DimensionIP}, bothFamilies, hashOptions, nil},
+	{TypeHashIPPortNet, []Dimension{DimensionIP, DimensionPort, DimensionNet}, bothFamilies, hashOptions, nil},
+	{TypeHashIPMark, []Dimension{DimensionIP, DimensionMark}, bothFamilies, append([]string{"markmask"}, hashOptions...), nil},
+	{TypeHashNetPortNet, []Dimension{DimensionNet, DimensionPort, DimensionNet}, bothFamilies, hashOptions, nil},
+	{TypeHashNetIface, []Dimension{DimensionNet, DimensionIface}, bothFamilies, hashOptions, nil},
+	{TypeBitmapIP, []Dimension{DimensionIP}, []Family{FamilyInet}, append([]string{"range", "netmask"}, extOptions...), []string{"range"}},
+	{TypeBitmapIPMAC, []Dimension{DimensionIP, DimensionMAC}, []Family{FamilyInet}, append([]string{"range"}, extOptions...), []string{"range"}},
+	{TypeBitmapPort, []Dimension{DimensionPort}, nil, append([]string{"range"}, extOptions...), []string{"range"}},
+	{TypeListSet, []Dimension{DimensionSet}, nil, append([]string{"size"}, extOptions...), nil},
+}
+
+// SetTypes returns the capabilities of every set type of current kernels.
+func SetTypes() []TypeCapabilities {
+	return slices.Clone(setTypes)
+}
+
+// Capabilities returns what sets of type t take, and false if t is not a
+// type of current kernels.
+func (t SetType) Capabilities() (TypeCapabilities, bool) {
+	for _, c := range setTypes {
+		if c.Type == t {
+			return c, true
+		}
+	}
+	return TypeCapabilities{}, false
+}
+
+// Validate checks that t is a type of current kernels.
+func (t SetType) Validate() error {
+	if _, ok := t.Capabilities(); !ok {
+		return fmt.Errorf("'%s': %w", t, ErrInvalidSetType)
+	}
+	return nil
+}
+
+// takes reports whether sets of the type take the create parameter.
+func (c TypeCapabilities) takes(option string) bool {
+	return slices.Contains(c.Options, option)
+}
+
+// Validate checks info against the capabilities of its type: that the type
+// is known, that the family is one the type holds and that every create
+// parameter is one the type takes. A family is ignored by types that don't
+// take one, as long as it is one they hold.
+func (info Info) Validate() error {
+	caps, ok := info.Type.Capabilities()
+	if !ok {
+		return fmt.Errorf("'%s': %w", info.Type, ErrInvalidSetType)
+	}
+
+	if info.Family != "" {
+		if err := info.Family.Validate(); err != nil {
+			return err
+		}
+		if len(caps.Families) > 0 && !slices.Contains(caps.Families, info.Family) {
+			return fmt.Errorf("%s sets don't hold %s addresses: %w", info.Type, info.Family, ErrInvalidFamily)
+		}
+	}
+
+	options := createOptionNames(info.createArgs()[2:])
+	for _, o := range options {
+		if !caps.takes(o) {
+			return fmt.Errorf("%s sets don't take %s: %w", info.Type, o, ErrUnsupportedOption)
+		}
+	}
+	for _, o := range caps.Required {
+		if !slices.Contains(options, o) {
+			return fmt.Errorf("%s sets need %s: %w", info.Type, o, ErrUnsupportedOption)
+		}
+	}
+
+	if info.PortRange != nil && caps.Dimensions[0] != DimensionPort {
+		return fmt.Errorf("%s sets don't take a port range: %w", info.Type, ErrUnsupportedOption)
+	}
+	if info.IPRange != nil && caps.Dimensions[0] != DimensionIP {
+		return fmt.Errorf("%s sets don't take an address range: %w", info.Type, ErrUnsupportedOption)
+	}
+	if info.PortRange != nil {
+		if err := info.PortRange.Validate(); err != nil {
+			return err
+		}
+	}
+	if info.IPRange != nil {
+		if err := info.IPRange.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createOptionNames returns the names of the parameters, skipping their
+// values.
+func createOptionNames(params []string) []string {
+	var names []string
+	for i := 0; i < len(params); i++ {
+		names = append(names, params[i])
+		if !isCreateFlag(params[i]) {
+			i++
+		}
+	}
+	return names
+}
+
+// checkShape verifies that the components of elem match the dimensions of
+// the set type and that its addresses are of the family of the set.
+// Addresses and networks are interchangeable, as libipset takes either in
+// both kinds of dimension. Elements of unknown types or in unknown shapes
+// pass.
+func (info Info) checkShape(elem Element) error {
+	caps, ok := info.Type.Capabilities()
+	dims, family := elementShape(elem)
+	if !ok || dims == nil {
+		return nil
+	}
+
+	match := len(dims) == len(caps.Dimensions)
+	for i := 0; match && i < len(dims); i++ {
+		match = dims[i] == caps.Dimensions[i] || isAddressDimension(dims[i]) && isAddressDimension(caps.Dimensions[i])
+	}
+	if !match {
+		return fmt.Errorf("%s sets take %s elements, not '%s': %w", info.Type, dimensionsString(caps.Dimensions), elem, ErrInvalidElement)
+	}
+
+	want := info.Family
+	if !caps.takes("family") && len(caps.Families) == 1 {
+		want = caps.Families[0]
+	}
+	if family != "" && want != "" && family != want {
+		return fmt.Errorf("'%s' is not an %s element: %w", elem, want, ErrInvalidElement)
+	}
+
+	return nil
+}
+
+// elementShape returns the dimensions of elem and the family of its
+// addresses, or nil if the shape of elem is not known.
+func elementShape(elem Element) ([]Dimension, Family) {
+	switch e := elem.(type) {
+	case net.IP:
+		return []Dimension{DimensionIP}, ipFamily(e)
+	case *net.IPNet:
+		return []Dimension{DimensionNet}, ipFamily(e.IP)
+	case IPRange:
+		return []Dimension{DimensionIP}, ipFamily(e.First)
+	case net.HardwareAddr:
+		return []Dimension{DimensionMAC}, ""
+	case IPMAC:
+		return []Dimension{DimensionIP, DimensionMAC}, ipFamily(e.IP)
+	case Port, ProtoPort, ICMP, ICMPv6:
+		return []Dimension{DimensionPort}, ""
+	case IPPort:
+		return []Dimension{DimensionIP, DimensionPort}, ipFamily(e.IP)
+	case NetPort:
+		return []Dimension{DimensionNet, DimensionPort}, netFamily(e.Net)
+	case NetNet:
+		return []Dimension{DimensionNet, DimensionNet}, netFamily(e.First)
+	case NetPortNet:
+		return []Dimension{DimensionNet, DimensionPort, DimensionNet}, netFamily(e.First)
+	case SetMember:
+		return []Dimension{DimensionSet}, ""
+	}
+	return nil, ""
+}
+
+func isAddressDimension(d Dimension) bool {
+	return d == DimensionIP || d == DimensionNet
+}
+
+func dimensionsString(dims []Dimension) string {
+	s := make([]string, len(dims))
+	for i, d := range dims {
+		s[i] = string(d)
+	}
+	return strings.Join(s, ",")
+}
+
+func ipFamily(ip net.IP) Family {
+	switch {
+	case ip == nil:
+		return ""
+	case ip.To4() != nil:
+		return FamilyInet
+	default:
+		return FamilyInet6
+	}
+}
+
+func netFamily(n *net.IPNet) Family {
+	if n == nil {
+		return ""
+	}
+	return ipFamily(n.IP)
+}
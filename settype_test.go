@@ -0,0 +1,132 @@
+package ipset
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestInfoValidate(t *testing.T) {
+	ipRange := IPRange{First: net.IPv4(10, 0, 0, 0), Last: net.IPv4(10, 0, 255, 255)}
+	portRange := PortRange{First: 0, Last: 1024}
+	size, hashsize, netmask := 4, 1024, 24
+	markmask := uint32(0xff)
+
+	cases := []struct {
+		info Info
+		err  error
+	}{
+		{Info{Type: TypeHashIP, Family: FamilyInet, HashSize: &hashsize, Netmask: &netmask, Counters: true}, nil},
+		{Info{Type: TypeHashNetPortNet, Family: FamilyInet6, ForceAdd: true}, nil},
+		{Info{Type: TypeHashIPMark, MarkMask: &markmask}, nil},
+		{Info{Type: TypeHashMAC, Family: FamilyInet}, nil},
+		{Info{Type: TypeBitmapIP, Family: FamilyInet, IPRange: &ipRange, Netmask: &netmask}, nil},
+		{Info{Type: TypeBitmapPort, Family: FamilyInet, PortRange: &portRange, Comment: true}, nil},
+		{Info{Type: TypeListSet, Size: &size}, nil},
+		{Info{Type: "hash:ipp"}, ErrInvalidSetType},
+		{Info{}, ErrInvalidSetType},
+		{Info{Type: TypeHashIP, Family: "inet4"}, ErrInvalidFamily},
+		{Info{Type: TypeBitmapIP, Family: FamilyInet6, IPRange: &ipRange}, ErrInvalidFamily},
+		{Info{Type: TypeHashNet, Netmask: &netmask}, ErrUnsupportedOption},
+		{Info{Type: TypeHashIP, MarkMask: &markmask}, ErrUnsupportedOption},
+		{Info{Type: TypeListSet, HashSize: &hashsize}, ErrUnsupportedOption},
+		{Info{Type: TypeHashIP, Size: &size}, ErrUnsupportedOption},
+		{Info{Type: TypeBitmapIP, ForceAdd: true, IPRange: &ipRange}, ErrUnsupportedOption},
+		{Info{Type: TypeBitmapIP}, ErrUnsupportedOption},
+		{Info{Type: TypeBitmapIP, PortRange: &portRange}, ErrUnsupportedOption},
+		{Info{Type: TypeBitmapPort, IPRange: &ipRange}, ErrUnsupportedOption},
+		{Info{Type: TypeBitmapPort, PortRange: &PortRange{First: 0, Last: 99999}}, ErrInvalidElement},
+		{Info{Type: TypeBitmapIP, IPRange: &IPRange{First: net.IPv4(10, 0, 0, 1), Last: net.ParseIP("::1")}}, ErrInvalidElement},
+	}
+
+	for _, c := range cases {
+		err := c.info.Validate()
+		if c.err == nil && err != nil {
+			t.Errorf("%s: unexpected error %v", c.info, err)
+		}
+		if c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("%s: error should be %v, was %v", c.info, c.err, err)
+		}
+	}
+}
+
+func TestCreateRejectsInvalidInfo(t *testing.T) {
+	set := New(OptionInterceptor(refuseAll(t)))
+	defer set.Close()
+
+	err := set.Create("bl", CreateOptionType("hash:ipp"))
+	if !errors.Is(err, ErrInvalidSetType) {
+		t.Errorf("error should be ErrInvalidSetType, was %v", err)
+	}
+
+	err = set.Create("bl", CreateOptionFamily("inet7"))
+	if !errors.Is(err, ErrInvalidFamily) {
+		t.Errorf("error should be ErrInvalidFamily, was %v", err)
+	}
+
+	err = set.Create("bl", CreateOptionType(TypeListSet), CreateOptionHashSize(64))
+	if !errors.Is(err, ErrUnsupportedOption) {
+		t.Errorf("error should be ErrUnsupportedOption, was %v", err)
+	}
+}
+
+func TestSetTypes(t *testing.T) {
+	for _, c := range SetTypes() {
+		if len(c.Dimensions) == 0 || len(c.Options) == 0 {
+			t.Errorf("%s: expected dimensions and options, was %+v", c.Type, c)
+		}
+		if typeHasFamily(c.Type) && len(c.Families) == 0 {
+			t.Errorf("%s: takes a family but holds none", c.Type)
+		}
+		for _, f := range c.Families {
+			if err := f.Validate(); err != nil {
+				t.Errorf("%s: %v", c.Type, err)
+			}
+		}
+	}
+
+	if typeHasFamily(TypeHashMAC) || typeHasFamily(TypeBitmapIP) || !typeHasFamily(TypeHashNetIface) {
+		t.Errorf("expected family for hash:net,iface only")
+	}
+	if _, ok := SetType("hash:ipp").Capabilities(); ok {
+		t.Errorf("expected no capabilities of unknown type")
+	}
+}
+
+func TestInfoCheckElementShape(t *testing.T) {
+	_, net4, _ := net.ParseCIDR("10.0.0.0/8")
+	_, net6, _ := net.ParseCIDR("2001:db8::/32")
+
+	cases := []struct {
+		info Info
+		elem Element
+		ok   bool
+	}{
+		{Info{Type: TypeHashIP, Family: FamilyInet}, net.IPv4(1, 2, 3, 4), true},
+		{Info{Type: TypeHashIP, Family: FamilyInet}, net4, true},
+		{Info{Type: TypeHashNet, Family: FamilyInet}, net.IPv4(1, 2, 3, 4), true},
+		{Info{Type: TypeHashIP, Family: FamilyInet}, net.ParseIP("::1"), false},
+		{Info{Type: TypeHashNet, Family: FamilyInet6}, net6, true},
+		{Info{Type: TypeHashNet, Family: FamilyInet6}, net4, false},
+		{Info{Type: TypeHashIP}, Port(80), false},
+		{Info{Type: TypeHashIPPort, Family: FamilyInet}, IPPort{IP: net.IPv4(1, 2, 3, 4), Port: Port(80)}, true},
+		{Info{Type: TypeHashIPPort, Family: FamilyInet}, NetNet{First: net4, Second: net4}, false},
+		{Info{Type: TypeHashIPPortNet, Family: FamilyInet}, NetPortNet{First: net4, Port: Port(80), Second: net4}, true},
+		{Info{Type: TypeBitmapIP}, net.ParseIP("::1"), false},
+		{Info{Type: TypeBitmapPort}, ProtoPort{Proto: "tcp", Port: 80}, true},
+		{Info{Type: TypeListSet}, SetMember{Name: "bl"}, true},
+		{Info{Type: TypeListSet}, net.IPv4(1, 2, 3, 4), false},
+		{Info{Type: TypeHashNetIface}, rawElement("10.0.0.0/8,eth0"), true},
+		{Info{Type: "hash:new"}, Port(80), true},
+	}
+
+	for _, c := range cases {
+		err := c.info.CheckElement(c.elem)
+		if c.ok && err != nil {
+			t.Errorf("%s in %s: unexpected error %v", c.elem, c.info.Type, err)
+		}
+		if !c.ok && !errors.Is(err, ErrInvalidElement) {
+			t.Errorf("%s in %s: error should be ErrInvalidElement, was %v", c.elem, c.info.Type, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ipset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Stats holds what the kernel reports in the header of a set listing.
+// Header carries the create parameters, including maxelem, in the same
+// form as Info. References counts iptables rules and list:set sets using
+// the set.
+type Stats struct {
+	Name       string  `json:"name"`
+	Type       SetType `json:"type"`
+	Revision   int     `json:"revision"`
+	Header     Info    `json:"header"`
+	MemSize    int     `json:"memory_bytes"`
+	References int     `json:"references"`
+	Entries    int     `json:"entries"`
+}
+
+// Utilisation returns the number of entries as a fraction of maxelem, or
+// zero if the set has no maxelem.
+func (s Stats) Utilisation() float64 {
+	if s.Header.MaxElem == nil || *s.Header.MaxElem <= 0 {
+		return 0
+	}
+	return float64(s.Entries) / float64(*s.Header.MaxElem)
+}
+
+// Names returns the names of all sets.
+func (set *IPSet) Names() ([]string, error) {
+	_, msg, err := set.run("list", "-n")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(msg), nil
+}
+
+func (set *IPSet) Stats(name string) (Stats, error) {
+	if err := ValidateSetName(name); err != nil {
+		return Stats{}, err
+	}
+
+	_, msg, err := set.run("list", "-t", name)
+
+	if err != nil {
+		return Stats{}, err
+	}
+
+	stats := parseListHeaders(msg)
+	if len(stats) == 0 {
+		return Stats{}, fmt.Errorf("no header in listing of %s", name)
+	}
+
+	return stats[0], nil
+}
+
+// parseListHeaders parses the output of list -t, which for each set looks
+// like
+//
+//	Name: bl
+//	Type: hash:ip
+//	Revision: 6
+//	Header: family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x3d8ad3e1
+//	Size in memory: 200
+//	References: 0
+//	Number of entries: 0
+func parseListHeaders(msg string) []Stats {
+	var stats []Stats
+
+	for _, line := range strings.Split(msg, "\n") {
+		key, val, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		val = strings.TrimSpace(val)
+
+		if key == "Name" {
+			stats = append(stats, Stats{Name: val, Header: Info{Name: val}})
+			continue
+		}
+		if len(stats) == 0 {
+			continue
+		}
+		s := &stats[len(stats)-1]
+
+		switch key {
+		case "Type":
+			s.Type = SetType(val)
+			s.Header.Type = s.Type
+		case "Revision":
+			s.Revision, _ = strconv.Atoi(val)
+		case "Header":
+			s.Header, _ = parseCreateParams(s.Header, strings.Fields(val))
+		case "Size in memory":
+			s.MemSize, _ = strconv.Atoi(val)
+		case "References":
+			s.References, _ = strconv.Atoi(val)
+		case "Number of entries":
+			s.Entries, _ = strconv.Atoi(val)
+		}
+	}
+
+	return stats
+}
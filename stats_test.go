@@ -0,0 +1,123 @@
+package ipset
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	stats, err := set.Stats(namedSetV4)
+	if err != nil {
+		t.Fatalf("unexpected error getting stats: %v", err)
+	}
+
+	if stats.Name != namedSetV4 {
+		t.Errorf("expected name '%s', was '%s'", namedSetV4, stats.Name)
+	}
+	if stats.Entries != 1 {
+		t.Errorf("expected 1 entry, was %d", stats.Entries)
+	}
+	if stats.MemSize <= 0 {
+		t.Errorf("expected memory size, was %d", stats.MemSize)
+	}
+	if stats.References != 0 {
+		t.Errorf("expected no references, was %d", stats.References)
+	}
+	if stats.Type != "hash:ip" {
+		t.Errorf("expected type 'hash:ip', was '%s'", stats.Type)
+	}
+	if stats.Revision <= 0 {
+		t.Errorf("expected revision, was %d", stats.Revision)
+	}
+	if stats.Header.MaxElem == nil {
+		t.Errorf("expected maxelem in header, was nil")
+	}
+
+	_, err = set.Stats(noSuchSet)
+	if !errors.Is(err, ErrSetNotFound) {
+		t.Errorf("error should be ErrSetNotFound, was %v", err)
+	}
+}
+
+func TestNames(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	set := New()
+	defer set.Close()
+
+	names, err := set.Names()
+	if err != nil {
+		t.Fatalf("unexpected error listing names: %v", err)
+	}
+
+	found := map[string]bool{}
+	for _, n := range names {
+		found[n] = true
+	}
+	if !found[namedSetV4] || !found[namedSetV6] || found[noSuchSet] {
+		t.Errorf("expected %s and %s but not %s, was %v", namedSetV4, namedSetV6, noSuchSet, names)
+	}
+}
+
+func TestParseListHeaders(t *testing.T) {
+	msg := `Name: bl
+Type: hash:ip
+Revision: 6
+Header: family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x3d8ad3e1
+Size in memory: 200
+References: 2
+Number of entries: 17
+Name: bll
+Type: list:set
+Revision: 3
+Header: size 8
+Size in memory: 88
+References: 0
+Number of entries: 1`
+
+	stats := parseListHeaders(msg)
+
+	expected := []Stats{
+		{Name: "bl", Type: "hash:ip", Revision: 6, MemSize: 200, References: 2, Entries: 17},
+		{Name: "bll", Type: "list:set", Revision: 3, MemSize: 88, References: 0, Entries: 1},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %d headers, was %d", len(expected), len(stats))
+	}
+	for i := range expected {
+		s := stats[i]
+		s.Header = Info{}
+		if s != expected[i] {
+			t.Errorf("expected %+v, was %+v", expected[i], s)
+		}
+		if stats[i].Header.Name != expected[i].Name || stats[i].Header.Type != expected[i].Type {
+			t.Errorf("expected header of %s %s, was %v", expected[i].Name, expected[i].Type, stats[i].Header)
+		}
+	}
+
+	bl := stats[0]
+	if bl.Header.Family != "inet" {
+		t.Errorf("expected family 'inet', was '%s'", bl.Header.Family)
+	}
+	if bl.Header.MaxElem == nil || *bl.Header.MaxElem != 65536 {
+		t.Errorf("expected maxelem 65536, was %v", bl.Header.MaxElem)
+	}
+	if u := bl.Utilisation(); u != 17.0/65536 {
+		t.Errorf("expected utilisation %v, was %v", 17.0/65536, u)
+	}
+
+	bll := stats[1]
+	if bll.Header.Size == nil || *bll.Header.Size != 8 {
+		t.Errorf("expected size 8, was %v", bll.Header.Size)
+	}
+	if u := bll.Utilisation(); u != 0 {
+		t.Errorf("expected no utilisation without maxelem, was %v", u)
+	}
+}
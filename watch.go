@@ -0,0 +1,160 @@
+package ipset
+
+import (
+	"context"
+	"time"
+)
+
+type WatchEventKind int
+
+const (
+	WatchAdded WatchEventKind = iota
+	WatchRemoved
+	WatchExpired
+	WatchError
+)
+
+func (k WatchEventKind) String() string {
+	switch k {
+	case WatchAdded:
+		return "added"
+	case WatchRemoved:
+		return "removed"
+	case WatchExpired:
+		return "expired"
+	case WatchError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// WatchEvent reports a change to the members of a watched set. For added
+// entries Entry is as currently listed, for removed and expired ones it is
+// as last seen. Err is only set for WatchError events.
+type WatchEvent struct {
+	Kind  WatchEventKind
+	Set   string
+	Entry Entry
+	Err   error
+}
+
+// Watch polls the members of the named set every interval and sends an
+// event for each element added or removed since the previous poll. A
+// removed element whose timeout had run out by the time of the poll is
+// reported as expired instead. The members present at the first poll that
+// succeeds are taken as the starting point and not reported.
+//
+// Failing polls are reported as WatchError events and watching goes on.
+// No other events are sent until a first poll has succeeded.
+// The channel is closed once ctx is done.
+func (set *IPSet) Watch(ctx context.Context, name string, interval time.Duration) <-chan WatchEvent {
+	ticker := time.NewTicker(interval)
+	return watch(ctx, name, ticker.C, ticker.Stop, func() ([]Entry, error) {
+		return set.Entries(name)
+	})
+}
+
+// watch is Watch polling list at once and then on every tick. stop is
+// called once watching ends.
+func watch(ctx context.Context, name string, ticks <-chan time.Time, stop func(), list func() ([]Entry, error)) <-chan WatchEvent {
+	events := make(chan WatchEvent)
+
+	go func() {
+		defer close(events)
+		defer stop()
+
+		send := func(ev WatchEvent) bool {
+			select {
+			case events <- ev:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		var prev, cur map[string]Entry
+		var prevTime time.Time
+
+		snapshot := func() (map[string]Entry, time.Time, error) {
+			entries, err := list()
+			now := time.Now()
+			if err != nil {
+				return nil, now, err
+			}
+
+			// Reuse the map from two polls ago to spare the garbage
+			// collector on large sets.
+			m := cur
+			if m == nil {
+				m = make(map[string]Entry, len(entries))
+			}
+			clear(m)
+			for _, e := range entries {
+				m[e.Element.String()] = e
+			}
+			return m, now, nil
+		}
+
+		var err error
+		prev, prevTime, err = snapshot()
+		if err != nil && !send(WatchEvent{Kind: WatchError, Set: name, Err: err}) {
+			return
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticks:
+			}
+
+			next, now, err := snapshot()
+			if err != nil {
+				if !send(WatchEvent{Kind: WatchError, Set: name, Err: err}) {
+					return
+				}
+				continue
+			}
+
+			// Without a starting point every member would be reported as
+			// added.
+			if prev != nil {
+				for _, ev := range diffSnapshots(name, prev, next, now.Sub(prevTime)) {
+					if !send(ev) {
+						return
+					}
+				}
+			}
+
+			cur, prev, prevTime = prev, next, now
+		}
+	}()
+
+	return events
+}
+
+// diffSnapshots returns the events that take the set from prev to next,
+// elapsed being the time between the two.
+func diffSnapshots(name string, prev map[string]Entry, next map[string]Entry, elapsed time.Duration) []WatchEvent {
+	var events []WatchEvent
+
+	for k, e := range next {
+		if _, ok := prev[k]; !ok {
+			events = append(events, WatchEvent{Kind: WatchAdded, Set: name, Entry: e})
+		}
+	}
+
+	for k, e := range prev {
+		if _, ok := next[k]; ok {
+			continue
+		}
+
+		kind := WatchRemoved
+		if e.Timeout != nil && time.Duration(*e.Timeout)*time.Second <= elapsed {
+			kind = WatchExpired
+		}
+		events = append(events, WatchEvent{Kind: kind, Set: name, Entry: e})
+	}
+
+	return events
+}
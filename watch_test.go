@@ -0,0 +1,181 @@
+package ipset
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	teardown := setup(t)
+	defer teardown(t)
+
+	listed := make(chan struct{}, 1)
+	set := New(OptionInterceptor(func(op Operation, next func() error) error {
+		err := next()
+		if op.Kind == OpSave {
+			select {
+			case listed <- struct{}{}:
+			default:
+			}
+		}
+		return err
+	}))
+	defer set.Close()
+
+	if err := set.Create(noSuchSet, CreateOptionTimeout(600)); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	set.Add(noSuchSet, net.IPv4(10, 0, 0, 1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events := set.Watch(ctx, noSuchSet, 100*time.Millisecond)
+
+	// Wait for the watcher to take its initial snapshot.
+	select {
+	case <-listed:
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for the initial snapshot")
+	}
+
+	set.Add(noSuchSet, net.IPv4(10, 0, 0, 2))
+	set.DelElement(noSuchSet, net.IPv4(10, 0, 0, 1))
+
+	var added, removed bool
+	for !added || !removed {
+		select {
+		case ev := <-events:
+			switch {
+			case ev.Kind == WatchAdded && ev.Entry.Element.String() == "10.0.0.2":
+				added = true
+			case ev.Kind == WatchRemoved && ev.Entry.Element.String() == "10.0.0.1":
+				removed = true
+			case ev.Kind == WatchError:
+				t.Fatalf("unexpected error watching: %v", ev.Err)
+			default:
+				t.Errorf("unexpected event %s %s", ev.Kind, ev.Entry.Element)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for events, added %v removed %v", added, removed)
+		}
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	short, long := 1, 600
+	a := Entry{Element: rawElement("10.0.0.1")}
+	b := Entry{Element: rawElement("10.0.0.2"), Timeout: &short}
+	c := Entry{Element: rawElement("10.0.0.3"), Timeout: &long}
+	d := Entry{Element: rawElement("10.0.0.4")}
+
+	prev := map[string]Entry{"10.0.0.1": a, "10.0.0.2": b, "10.0.0.3": c}
+	next := map[string]Entry{"10.0.0.1": a, "10.0.0.4": d}
+
+	kinds := map[string]WatchEventKind{}
+	for _, ev := range diffSnapshots("bl", prev, next, 2*time.Second) {
+		if ev.Set != "bl" {
+			t.Errorf("expected set 'bl', was '%s'", ev.Set)
+		}
+		kinds[ev.Entry.Element.String()] = ev.Kind
+	}
+
+	expected := map[string]WatchEventKind{
+		"10.0.0.2": WatchExpired,
+		"10.0.0.3": WatchRemoved,
+		"10.0.0.4": WatchAdded,
+	}
+	if len(kinds) != len(expected) {
+		t.Errorf("expected events %v, was %v", expected, kinds)
+	}
+	for k, kind := range expected {
+		if kinds[k] != kind {
+			t.Errorf("element %s: expected %s, was %s", k, kind, kinds[k])
+		}
+	}
+}
+
+// polls answers the polls of a watcher one at a time, each poll waiting
+// for its answer. It gives up once ctx is done.
+type polls struct {
+	t       *testing.T
+	ctx     context.Context
+	ticks   chan time.Time
+	answers chan pollAnswer
+}
+
+type pollAnswer struct {
+	entries []Entry
+	err     error
+}
+
+func newPolls(t *testing.T, ctx context.Context) *polls {
+	return &polls{t: t, ctx: ctx, ticks: make(chan time.Time), answers: make(chan pollAnswer)}
+}
+
+func (p *polls) list() ([]Entry, error) {
+	select {
+	case a := <-p.answers:
+		return a.entries, a.err
+	case <-p.ctx.Done():
+		return nil, p.ctx.Err()
+	}
+}
+
+// answer lets the pending poll return, first ticking unless it is the
+// initial one.
+func (p *polls) answer(tick bool, entries []Entry, err error) {
+	p.t.Helper()
+	if tick {
+		select {
+		case p.ticks <- time.Now():
+		case <-p.ctx.Done():
+			p.t.Fatalf("timed out waiting for the watcher to poll")
+		}
+	}
+	select {
+	case p.answers <- pollAnswer{entries, err}:
+	case <-p.ctx.Done():
+		p.t.Fatalf("timed out waiting for the watcher to poll")
+	}
+}
+
+func TestWatchFailingStart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p := newPolls(t, ctx)
+	events := watch(ctx, "bl", p.ticks, func() {}, p.list)
+
+	next := func() WatchEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for events")
+		}
+		return WatchEvent{}
+	}
+
+	a := Entry{Element: rawElement("10.0.0.1")}
+	b := Entry{Element: rawElement("10.0.0.2")}
+
+	// The first two polls fail, the third is the starting point.
+	p.answer(false, nil, errors.New("list failed"))
+	if ev := next(); ev.Kind != WatchError {
+		t.Fatalf("expected error event, was %s %s", ev.Kind, ev.Entry.Element)
+	}
+	p.answer(true, nil, errors.New("list failed"))
+	if ev := next(); ev.Kind != WatchError {
+		t.Fatalf("expected error event, was %s %s", ev.Kind, ev.Entry.Element)
+	}
+	p.answer(true, []Entry{a}, nil)
+	p.answer(true, []Entry{a, b}, nil)
+
+	if ev := next(); ev.Kind != WatchAdded || ev.Entry.Element.String() != "10.0.0.2" {
+		t.Errorf("expected 10.0.0.2 added, was %s %s", ev.Kind, ev.Entry.Element)
+	}
+}